@@ -0,0 +1,196 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+// ArchiverStats tells how WAL archiving went so far, as reported by pg_stat_archiver.
+// Times are zero if nothing was archived (or failed) yet.
+type ArchiverStats struct {
+	ArchivedCount    int64
+	LastArchivedWAL  string
+	LastArchivedTime time.Time
+	FailedCount      int64
+	LastFailedWAL    string
+	LastFailedTime   time.Time
+
+	// CurrentWAL is the segment the cluster is currently writing to
+	CurrentWAL string
+}
+
+// ArchiverStats reads the statistics of the WAL archiver of a running primary
+func (ctl Controller) ArchiverStats() (*ArchiverStats, *pitr.Error) {
+	version, parseErr := pitr.ParseVersion(ctl.Version)
+
+	if parseErr != nil {
+		return nil, &pitr.Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	currentWAL := "pg_walfile_name(pg_current_wal_lsn())"
+
+	if version.Compare(pitr.FirstVersionWithPgWal) < 0 {
+		currentWAL = "pg_xlogfile_name(pg_current_xlog_location())"
+	}
+
+	rows, err := ctl.Query(
+		"select archived_count, coalesce(last_archived_wal, ''), coalesce(extract(epoch from last_archived_time)::bigint, 0)," +
+			" failed_count, coalesce(last_failed_wal, ''), coalesce(extract(epoch from last_failed_time)::bigint, 0), " +
+			currentWAL +
+			" from pg_stat_archiver",
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if 1 != len(rows) || 7 != len(rows[0]) {
+		return nil, &pitr.Error{
+			Message: fmt.Sprintf("Error parsing archiver statistics '%v'; expected a single row with 7 columns", rows),
+		}
+	}
+
+	row := rows[0]
+	numbers := make([]int64, 0, 4)
+
+	for _, column := range []string{row[0], row[2], row[3], row[5]} {
+		number, convErr := strconv.ParseInt(column, 10, 64)
+
+		if convErr != nil {
+			return nil, &pitr.Error{
+				Message: convErr.Error(),
+			}
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	return &ArchiverStats{
+		ArchivedCount:    numbers[0],
+		LastArchivedWAL:  row[1],
+		LastArchivedTime: unixTime(numbers[1]),
+		FailedCount:      numbers[2],
+		LastFailedWAL:    row[4],
+		LastFailedTime:   unixTime(numbers[3]),
+		CurrentWAL:       row[6],
+	}, nil
+}
+
+// SwitchWAL completes the current WAL segment so that it can be archived, and returns its name
+func (ctl Controller) SwitchWAL() (string, *pitr.Error) {
+	version, parseErr := pitr.ParseVersion(ctl.Version)
+
+	if parseErr != nil {
+		return "", &pitr.Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	sql := "select pg_walfile_name(pg_switch_wal())"
+
+	if version.Compare(pitr.FirstVersionWithPgWal) < 0 {
+		sql = "select pg_xlogfile_name(pg_switch_xlog())"
+	}
+
+	rows, err := ctl.Query(sql)
+
+	if err != nil {
+		return "", err
+	}
+
+	if 1 != len(rows) {
+		return "", &pitr.Error{
+			Message: fmt.Sprintf("Expected a single row, but found %d", len(rows)),
+		}
+	}
+
+	return rows[0][0], nil
+}
+
+// IsFailing returns true if the most recent attempt to archive a segment failed
+func (stats ArchiverStats) IsFailing() bool {
+	return 0 < stats.FailedCount && stats.LastFailedTime.After(stats.LastArchivedTime)
+}
+
+// Setting returns the current value of the given configuration parameter
+func (ctl Controller) Setting(name string) (string, *pitr.Error) {
+	if !isIdentifier(name) {
+		return "", &pitr.Error{
+			Message: fmt.Sprintf("Invalid setting name '%s'", name),
+		}
+	}
+
+	rows, err := ctl.Query(fmt.Sprintf("select current_setting('%s')", name))
+
+	if err != nil {
+		return "", err
+	}
+
+	if 1 != len(rows) {
+		return "", &pitr.Error{
+			Message: fmt.Sprintf("Expected a single row, but found %d", len(rows)),
+		}
+	}
+
+	return strings.Join(rows[0], "|"), nil
+}
+
+// WALDirectory provides the file system location of the WAL
+func (ctl Controller) WALDirectory() (string, *pitr.Error) {
+	version, parseErr := pitr.ParseVersion(ctl.Version)
+
+	if parseErr != nil {
+		return "", &pitr.Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	if version.Compare(pitr.FirstVersionWithPgWal) < 0 {
+		return ctl.DataDirectory() + "/pg_xlog", nil
+	}
+
+	return ctl.DataDirectory() + "/pg_wal", nil
+}
+
+// ReadyWAL lists the segments that are waiting to be archived
+func (ctl Controller) ReadyWAL() ([]string, *pitr.Error) {
+	walDirectory, err := ctl.WALDirectory()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres find %s/archive_status -name '*.ready' -printf '%%f\\n'", walDirectory)
+
+	if runErr != nil {
+		return nil, &pitr.Error{
+			Message: runErr.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	ready := make([]string, 0)
+
+	for _, line := range strings.Split(stdout, "\n") {
+		if "" != strings.TrimSpace(line) {
+			ready = append(ready, strings.TrimSuffix(strings.TrimSpace(line), ".ready"))
+		}
+	}
+
+	return ready, nil
+}
+
+func unixTime(seconds int64) time.Time {
+	if 0 == seconds {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0)
+}
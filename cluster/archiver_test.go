@@ -0,0 +1,61 @@
+package cluster_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	clstr "github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+)
+
+var _ = Describe("WAL archiver", func() {
+	var runner *h.FakeRunner
+	var cluster clstr.Controller
+
+	BeforeEach(func() {
+		runner = &h.FakeRunner{}
+		runner.On("from pg_stat_archiver", "11|00000001000000000000000B|1574763990|2|00000001000000000000000C|1574764000|00000001000000000000000D\n", "", nil)
+		runner.On("pg_switch_wal()", "00000001000000000000000D\n", "", nil)
+		runner.On("pg_switch_xlog()", "00000001000000000000000D\n", "", nil)
+		runner.On("archive_status -name '*.ready'", "00000001000000000000000C.ready\n", "", nil)
+
+		cluster = clstr.NewController(runner, "11", "main")
+	})
+
+	It("has the statistics", func() {
+		stats, err := cluster.ArchiverStats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.ArchivedCount).To(Equal(int64(11)))
+		Expect(stats.LastArchivedWAL).To(Equal("00000001000000000000000B"))
+		Expect(stats.LastFailedTime).To(BeTemporally("==", time.Date(2019, 11, 26, 10, 26, 40, 0, time.UTC)))
+		Expect(stats.CurrentWAL).To(Equal("00000001000000000000000D"))
+		Expect(stats.IsFailing()).To(BeTrue())
+	})
+
+	It("lists the segments waiting to be archived", func() {
+		Expect(cluster.ReadyWAL()).To(ConsistOf("00000001000000000000000C"))
+		Expect(runner.CommandsContaining("archive_status")).To(ConsistOf(HavePrefix("sudo --user postgres find /var/lib/postgresql/11/main/pg_wal/archive_status")))
+	})
+
+	It("switches to a new segment", func() {
+		Expect(cluster.SwitchWAL()).To(Equal("00000001000000000000000D"))
+		Expect(runner.CommandsContaining("pg_switch_wal()")).To(HaveLen(1))
+	})
+
+	Context("before PostgreSQL 10", func() {
+		BeforeEach(func() {
+			cluster = clstr.NewController(runner, "9.6", "main")
+		})
+
+		It("switches to a new segment", func() {
+			Expect(cluster.SwitchWAL()).To(Equal("00000001000000000000000D"))
+			Expect(runner.CommandsContaining("pg_switch_xlog()")).To(HaveLen(1))
+		})
+
+		It("looks into pg_xlog", func() {
+			cluster.ReadyWAL()
+			Expect(runner.CommandsContaining("archive_status")).To(ConsistOf(ContainSubstring("/pg_xlog/archive_status")))
+		})
+	})
+})
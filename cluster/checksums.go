@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+var checksumFailurePattern = regexp.MustCompile(`checksum verification failed in file "([^"]+)", block (\d+): calculated checksum ([0-9A-Fa-f]+) but block contains ([0-9A-Fa-f]+)`)
+
+// ChecksumFailure describes a data page whose checksum does not match its content
+type ChecksumFailure struct {
+	File       string
+	Block      int
+	Calculated string
+	Found      string
+}
+
+// SharedObjects stands in for the name of a database in the checksum failures of the shared system catalogs,
+// which belong to no database
+const SharedObjects = "shared objects"
+
+// DatabaseChecksumFailures tells how many checksum failures a running cluster detected in a database
+type DatabaseChecksumFailures struct {
+	Database    string
+	Failures    int
+	LastFailure string
+}
+
+// ChecksumReport is the result of verifying the data checksums of a cluster.
+// Verifying a stopped cluster scans all files and reports each corrupt block;
+// a running cluster only reports the failures it came across, per database.
+type ChecksumReport struct {
+	FilesScanned  int
+	BlocksScanned int
+	BadChecksums  int
+	Failures      []ChecksumFailure
+	Databases     []DatabaseChecksumFailures
+}
+
+// OK returns true if no checksum failures were found
+func (report ChecksumReport) OK() bool {
+	if report.BadChecksums > 0 || len(report.Failures) > 0 {
+		return false
+	}
+
+	for _, database := range report.Databases {
+		if database.Failures > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VerifyChecksums checks the cluster for corrupt data pages. A stopped cluster is scanned with
+// pg_checksums (pg_verify_checksums on version 11); a running one is asked for the failures it detected so far.
+func (ctl Controller) VerifyChecksums() (*ChecksumReport, *pitr.Error) {
+	version, parseErr := pitr.ParseVersion(ctl.Version)
+
+	if parseErr != nil {
+		return nil, &pitr.Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	running, err := ctl.IsRunning()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if running {
+		return ctl.checksumFailuresOfRunningCluster(version)
+	}
+
+	return ctl.scanChecksums(version)
+}
+
+func (ctl Controller) checksumFailuresOfRunningCluster(version pitr.Version) (*ChecksumReport, *pitr.Error) {
+	if version.Major().Compare(pitr.FirstVersionWithChecksumFailureStats) < 0 {
+		return nil, &pitr.Error{
+			Message: fmt.Sprintf("A running cluster reports checksum failures from version 12 on, but this one runs %s; stop it to scan its files", version.MajorString()),
+		}
+	}
+
+	rows, err := ctl.Query(
+		"select datname, checksum_failures, coalesce(checksum_last_failure::text, '')" +
+			" from pg_stat_database where checksum_failures is not null order by datname nulls first",
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := ChecksumReport{
+		Databases: make([]DatabaseChecksumFailures, len(rows)),
+	}
+
+	for i, row := range rows {
+		if 3 != len(row) {
+			return nil, &pitr.Error{
+				Message: fmt.Sprintf("Error parsing checksum failures '%v'; expected 3 columns, but found %d", row, len(row)),
+			}
+		}
+
+		failures, parseErr := strconv.Atoi(row[1])
+
+		if parseErr != nil {
+			return nil, &pitr.Error{
+				Message: parseErr.Error(),
+			}
+		}
+
+		database := row[0]
+
+		// the row of the shared system catalogs has no database name
+		if "" == database {
+			database = SharedObjects
+		}
+
+		report.Databases[i] = DatabaseChecksumFailures{
+			Database:    database,
+			Failures:    failures,
+			LastFailure: row[2],
+		}
+	}
+
+	return &report, nil
+}
+
+func (ctl Controller) scanChecksums(version pitr.Version) (*ChecksumReport, *pitr.Error) {
+	var command string
+
+	switch major := version.Major(); {
+	case major.Compare(pitr.FirstVersionWithOfflineChecksums) < 0:
+		return nil, &pitr.Error{
+			Message: fmt.Sprintf("Verifying checksums of a stopped cluster requires version 11 or later, but this one runs %s", version.MajorString()),
+		}
+	case major.Compare(pitr.FirstVersionWithPgChecksums) < 0:
+		command = "pg_verify_checksums"
+	default:
+		command = "pg_checksums --check"
+	}
+
+	stdout, stderr, err := ctl.runner.Run("sudo --user postgres /usr/lib/postgresql/%s/bin/%s -D %s", ctl.Version, command, ctl.DataDirectory())
+	report, parseErr := ParseChecksumOutput(stdout + "\n" + stderr)
+
+	// the tools exit with a non-zero status when they found corruption, but then they did their job
+	if err != nil && (parseErr != nil || 0 == report.BadChecksums) {
+		return nil, &pitr.Error{
+			Message: "Could not verify the checksums of the cluster",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	if parseErr != nil {
+		return nil, &pitr.Error{
+			Message: parseErr.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return report, nil
+}
+
+// ParseChecksumOutput parses the combined output of pg_checksums or pg_verify_checksums
+func ParseChecksumOutput(output string) (*ChecksumReport, error) {
+	report := ChecksumReport{
+		Failures: []ChecksumFailure{},
+	}
+
+	completed := false
+
+	for _, line := range strings.Split(output, "\n") {
+		if match := checksumFailurePattern.FindStringSubmatch(line); match != nil {
+			block, err := strconv.Atoi(match[2])
+
+			if err != nil {
+				return nil, err
+			}
+
+			report.Failures = append(report.Failures, ChecksumFailure{
+				File:       match[1],
+				Block:      block,
+				Calculated: match[3],
+				Found:      match[4],
+			})
+
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+
+		if 2 != len(parts) {
+			continue
+		}
+
+		var target *int
+
+		switch strings.TrimSpace(parts[0]) {
+		case "Files scanned":
+			target = &report.FilesScanned
+		case "Blocks scanned":
+			target = &report.BlocksScanned
+		case "Bad checksums":
+			target = &report.BadChecksums
+		default:
+			continue
+		}
+
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing line '%s': %s", line, err)
+		}
+
+		*target = value
+		completed = true
+	}
+
+	if !completed {
+		return nil, fmt.Errorf("Error parsing checksum output; no summary found")
+	}
+
+	return &report, nil
+}
@@ -0,0 +1,138 @@
+package cluster_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	clstr "github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+)
+
+const pgVerifyChecksumsOutput = `pg_verify_checksums: checksum verification failed in file "/var/lib/postgresql/11/main/base/16384/16385", block 0: calculated checksum 1B3D but block contains 5A2F
+pg_verify_checksums: checksum verification failed in file "/var/lib/postgresql/11/main/base/16384/16385", block 7: calculated checksum C0DE but block contains BEEF
+Checksum scan completed
+Data checksum version: 1
+Files scanned:  931
+Blocks scanned: 2814
+Bad checksums:  2
+`
+
+const pgChecksumsOutput = `Checksum operation completed
+Files scanned:   1242
+Blocks scanned:  4028
+Bad checksums:  0
+Data checksum version: 1
+`
+
+var _ = Describe("Checksums", func() {
+	var runner *h.FakeRunner
+	var cluster clstr.Controller
+	var report *clstr.ChecksumReport
+
+	BeforeEach(func() {
+		runner = &h.FakeRunner{}
+		runner.On("pg_ctlcluster", "", "", h.ExitError{Status: 3})
+	})
+
+	Context("a stopped cluster of version 11 with corrupt blocks", func() {
+		BeforeEach(func() {
+			runner.On("pg_verify_checksums", "", pgVerifyChecksumsOutput, h.ExitError{Status: 1})
+			cluster = clstr.NewController(runner, "11", "main")
+
+			var err error
+			report, err = cluster.VerifyChecksums()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("scans the data directory", func() {
+			Expect(runner.CommandsContaining("/usr/lib/postgresql/11/bin/pg_verify_checksums -D /var/lib/postgresql/11/main")).To(HaveLen(1))
+		})
+
+		It("has the summary", func() {
+			Expect(report.FilesScanned).To(Equal(931))
+			Expect(report.BlocksScanned).To(Equal(2814))
+			Expect(report.BadChecksums).To(Equal(2))
+			Expect(report.OK()).To(BeFalse())
+		})
+
+		It("has the details of each failure", func() {
+			Expect(report.Failures).To(HaveLen(2))
+			Expect(report.Failures[1]).To(Equal(clstr.ChecksumFailure{
+				File:       "/var/lib/postgresql/11/main/base/16384/16385",
+				Block:      7,
+				Calculated: "C0DE",
+				Found:      "BEEF",
+			}))
+		})
+	})
+
+	Context("a stopped cluster of version 12 without corruption", func() {
+		BeforeEach(func() {
+			runner.On("pg_checksums", pgChecksumsOutput, "", nil)
+			cluster = clstr.NewController(runner, "12", "main")
+
+			var err error
+			report, err = cluster.VerifyChecksums()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("scans the data directory", func() {
+			Expect(runner.CommandsContaining("/usr/lib/postgresql/12/bin/pg_checksums --check -D /var/lib/postgresql/12/main")).To(HaveLen(1))
+		})
+
+		It("is OK", func() {
+			Expect(report.FilesScanned).To(Equal(1242))
+			Expect(report.Failures).To(BeEmpty())
+			Expect(report.OK()).To(BeTrue())
+		})
+	})
+
+	Context("a stopped cluster without checksums", func() {
+		BeforeEach(func() {
+			runner.On("pg_checksums", "", "pg_checksums: error: data checksums are not enabled in cluster", h.ExitError{Status: 1})
+			cluster = clstr.NewController(runner, "12", "main")
+		})
+
+		It("fails", func() {
+			_, err := cluster.VerifyChecksums()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Stderr).To(ContainSubstring("not enabled"))
+		})
+	})
+
+	Context("a running cluster of version 12", func() {
+		BeforeEach(func() {
+			runner.On("pg_ctlcluster", "", "", nil)
+			runner.On("from pg_stat_database", "|1|2019-01-21 10:40:02.482911+00\npostgres|0|\nsandbox|3|2019-01-21 10:42:23.123456+00\n", "", nil)
+			cluster = clstr.NewController(runner, "12", "main")
+
+			var err error
+			report, err = cluster.VerifyChecksums()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("does not scan the files", func() {
+			Expect(runner.CommandsContaining("pg_checksums")).To(BeEmpty())
+		})
+
+		It("has the failures per database", func() {
+			Expect(report.Databases).To(Equal([]clstr.DatabaseChecksumFailures{
+				{Database: clstr.SharedObjects, Failures: 1, LastFailure: "2019-01-21 10:40:02.482911+00"},
+				{Database: "postgres", Failures: 0},
+				{Database: "sandbox", Failures: 3, LastFailure: "2019-01-21 10:42:23.123456+00"},
+			}))
+			Expect(report.OK()).To(BeFalse())
+		})
+	})
+
+	Context("a running cluster of version 11", func() {
+		BeforeEach(func() {
+			runner.On("pg_ctlcluster", "", "", nil)
+			cluster = clstr.NewController(runner, "11", "main")
+		})
+
+		It("cannot report failures", func() {
+			_, err := cluster.VerifyChecksums()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
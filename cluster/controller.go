@@ -2,25 +2,58 @@ package cluster
 
 import (
 	"fmt"
+	"time"
+
 	pitr "github.com/suhlig/postgres-pitr"
-	"golang.org/x/crypto/ssh"
 )
 
+// exitStatusError is implemented by errors of commands that exited with a non-zero status, like ssh.ExitError
+type exitStatusError interface {
+	ExitStatus() int
+}
+
 // Controller provides a way to control a PostgreSQL cluster with the given version and name.
 // It performs its actions using the provided Runner.
 type Controller struct {
 	runner  pitr.Runner
 	Version string
 	Name    string
+
+	// PollInterval is the time between two checks while waiting
+	PollInterval time.Duration
 }
 
 // NewController creates a new controller for the cluster with the given version and name
 // All actions will be performed using the passed Runner.
 func NewController(runner pitr.Runner, version, name string) Controller {
 	return Controller{
-		runner:  runner,
-		Version: version,
-		Name:    name,
+		runner:       runner,
+		Version:      version,
+		Name:         name,
+		PollInterval: time.Second,
+	}
+}
+
+// waitFor checks the condition until it is met or the timeout has passed
+func (ctl Controller) waitFor(timeout time.Duration, condition func() (bool, *pitr.Error), message string) *pitr.Error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		done, err := condition()
+
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return &pitr.Error{Message: message}
+		}
+
+		time.Sleep(ctl.PollInterval)
 	}
 }
 
@@ -43,7 +76,7 @@ func (ctl Controller) Start() *pitr.Error {
 func (ctl Controller) IsRunning() (bool, *pitr.Error) {
 	stdout, stderr, err := ctl.runner.Run("sudo pg_ctlcluster %s %s status", ctl.Version, ctl.Name)
 
-	if result, ok := err.(*ssh.ExitError); ok {
+	if result, ok := err.(exitStatusError); ok {
 		if result.ExitStatus() == 3 { // server is stopped
 			return false, nil
 		}
@@ -0,0 +1,110 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+// Exists returns true if the cluster has been created
+func (ctl Controller) Exists() (bool, *pitr.Error) {
+	stdout, stderr, err := ctl.runner.Run("test -d /etc/postgresql/%s/%s", ctl.Version, ctl.Name)
+
+	if _, ok := err.(exitStatusError); ok {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, &pitr.Error{
+			Message: err.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return true, nil
+}
+
+// Create creates the cluster with pg_createcluster, which assigns the next free port. The cluster is not started.
+func (ctl Controller) Create() *pitr.Error {
+	stdout, stderr, err := ctl.runner.Run("sudo pg_createcluster %s %s", ctl.Version, ctl.Name)
+
+	if err != nil {
+		return &pitr.Error{
+			Message: "Could not create the cluster",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return nil
+}
+
+// Configure sets the given parameter in the postgresql.conf of the cluster with pg_conftool. It takes effect
+// with the next start or reload.
+func (ctl Controller) Configure(name, value string) *pitr.Error {
+	stdout, stderr, err := ctl.runner.Run("sudo pg_conftool %s %s set %s %s", ctl.Version, ctl.Name, name, value)
+
+	if err != nil {
+		return &pitr.Error{
+			Message: fmt.Sprintf("Could not set %s of the cluster", name),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return nil
+}
+
+// Drop stops the cluster, if running, and removes it including its data directory and configuration
+func (ctl Controller) Drop() *pitr.Error {
+	stdout, stderr, err := ctl.runner.Run("sudo pg_dropcluster --stop %s %s", ctl.Version, ctl.Name)
+
+	if err != nil {
+		return &pitr.Error{
+			Message: "Could not drop the cluster",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return nil
+}
+
+// Port returns the port the cluster is configured to listen on
+func (ctl Controller) Port() (int, *pitr.Error) {
+	stdout, stderr, err := ctl.runner.Run("pg_lsclusters --no-header %s %s", ctl.Version, ctl.Name)
+
+	if err != nil {
+		return 0, &pitr.Error{
+			Message: err.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	// Ver Cluster Port Status Owner Data directory Log file
+	fields := strings.Fields(stdout)
+
+	if len(fields) < 3 {
+		return 0, &pitr.Error{
+			Message: fmt.Sprintf("Could not find the port of cluster %s/%s", ctl.Version, ctl.Name),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	port, convErr := strconv.Atoi(fields[2])
+
+	if convErr != nil {
+		return 0, &pitr.Error{
+			Message: convErr.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return port, nil
+}
@@ -0,0 +1,80 @@
+package cluster_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	clstr "github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+)
+
+var _ = Describe("Cluster lifecycle", func() {
+	var runner *h.FakeRunner
+	var cluster clstr.Controller
+
+	BeforeEach(func() {
+		runner = &h.FakeRunner{}
+		cluster = clstr.NewController(runner, "13", "copy")
+	})
+
+	It("creates the cluster", func() {
+		Expect(cluster.Create()).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo pg_createcluster 13 copy"))
+	})
+
+	It("drops the cluster", func() {
+		Expect(cluster.Drop()).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo pg_dropcluster --stop 13 copy"))
+	})
+
+	Context("the cluster exists", func() {
+		It("knows", func() {
+			exists, err := cluster.Exists()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeTrue())
+		})
+	})
+
+	Context("the cluster does not exist", func() {
+		BeforeEach(func() {
+			runner.On("test -d /etc/postgresql/13/copy", "", "", h.ExitError{Status: 1})
+		})
+
+		It("knows", func() {
+			exists, err := cluster.Exists()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+	})
+
+	Context("the connection fails", func() {
+		BeforeEach(func() {
+			runner.On("test -d", "", "", errors.New("connection refused"))
+		})
+
+		It("does not pretend to know", func() {
+			_, err := cluster.Exists()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("the cluster listens on a port", func() {
+		BeforeEach(func() {
+			runner.On("pg_lsclusters --no-header 13 copy", "13  copy    5434 online postgres /var/lib/postgresql/13/copy /var/log/postgresql/postgresql-13-copy.log\n", "", nil)
+		})
+
+		It("provides the port", func() {
+			port, err := cluster.Port()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(port).To(Equal(5434))
+		})
+	})
+
+	Context("pg_lsclusters prints nothing", func() {
+		It("fails", func() {
+			_, err := cluster.Port()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"regexp"
+	"strings"
+
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+var identifierPattern = regexp.MustCompile(`^[a-zA-Z0-9_]{1,63}$`)
+
+// Query runs the given SQL statement against the postgres database of the cluster
+// and returns the rows of the result, each row split into its columns
+func (ctl Controller) Query(sql string) ([][]string, *pitr.Error) {
+	return ctl.QueryDatabase("postgres", sql)
+}
+
+// QueryDatabase runs the given SQL statement against the given database of the cluster
+// and returns the rows of the result, each row split into its columns
+func (ctl Controller) QueryDatabase(database, sql string) ([][]string, *pitr.Error) {
+	stdout, stderr, err := ctl.runner.Run(
+		"sudo --user postgres psql"+
+			" --cluster %s/%s"+
+			" --dbname=%s"+
+			" --no-psqlrc"+
+			" --no-align"+
+			" --tuples-only"+
+			" --field-separator='|'"+
+			" --command=\"%s\"",
+		ctl.Version,
+		ctl.Name,
+		database,
+		sql,
+	)
+
+	if err != nil {
+		return nil, &pitr.Error{
+			Message: err.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return parseRows(stdout), nil
+}
+
+func parseRows(stdout string) [][]string {
+	rows := make([][]string, 0)
+
+	for _, line := range strings.Split(stdout, "\n") {
+		if 0 == len(line) {
+			continue
+		}
+
+		rows = append(rows, strings.Split(line, "|"))
+	}
+
+	return rows
+}
+
+func isIdentifier(name string) bool {
+	return identifierPattern.MatchString(name)
+}
@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+// recoverySettings match the settings that an earlier recovery may have left in postgresql.auto.conf.
+// Like pgbackrest does on restore, all of them are removed, not only those being set again.
+var recoverySettings = []string{`restore_command`, `recovery_target[a-z_]*`, `primary_conninfo`, `primary_slot_name`}
+
+// MajorVersion detects the major version of the data directory
+func (ctl Controller) MajorVersion() (pitr.Version, *pitr.Error) {
+	stdout, stderr, err := ctl.runner.Run("sudo --user postgres cat %s/PG_VERSION", ctl.DataDirectory())
+
+	if err != nil {
+		return pitr.Version{}, &pitr.Error{
+			Message: "Could not detect the version of the data directory",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	version, err := pitr.ParseVersion(stdout)
+
+	if err != nil {
+		return pitr.Version{}, &pitr.Error{
+			Message: err.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return version, nil
+}
+
+// ConfigureRecovery prepares the cluster to recover with the given settings on its next start
+func (ctl Controller) ConfigureRecovery(settings ...string) *pitr.Error {
+	return ctl.configureRecovery(false, settings)
+}
+
+// ConfigureStandby prepares the cluster to start as a standby with the given settings
+func (ctl Controller) ConfigureStandby(settings ...string) *pitr.Error {
+	return ctl.configureRecovery(true, settings)
+}
+
+// Up to version 11, settings go into recovery.conf. Later versions read them from
+// the regular configuration and start recovery if a signal file is present.
+func (ctl Controller) configureRecovery(standby bool, settings []string) *pitr.Error {
+	version, err := ctl.MajorVersion()
+
+	if err != nil {
+		return err
+	}
+
+	if version.Compare(pitr.FirstVersionWithSignalFiles) < 0 {
+		if standby {
+			settings = append([]string{"standby_mode = 'on'"}, settings...)
+		}
+
+		return ctl.writeDataFile("recovery.conf", settings)
+	}
+
+	err = ctl.replaceAutoConfSettings(settings)
+
+	if err != nil {
+		return err
+	}
+
+	signal, other := "recovery.signal", "standby.signal"
+
+	if standby {
+		signal, other = other, signal
+	}
+
+	// standby.signal takes precedence, so a leftover one would keep a recovering cluster from being promoted
+	err = ctl.removeDataFile(other)
+
+	if err != nil {
+		return err
+	}
+
+	return ctl.writeDataFile(signal, nil)
+}
+
+// replaceAutoConfSettings removes earlier recovery settings and earlier values of the given settings
+// from postgresql.auto.conf and appends the new ones
+func (ctl Controller) replaceAutoConfSettings(settings []string) *pitr.Error {
+	names := append([]string{}, recoverySettings...)
+
+	for _, setting := range settings {
+		names = append(names, regexp.QuoteMeta(strings.TrimSpace(strings.SplitN(setting, "=", 2)[0])))
+	}
+
+	stdout, stderr, err := ctl.runner.Run(
+		`sudo --user postgres sed --in-place --regexp-extended '/^\s*(%s)\s*=/d' %s/postgresql.auto.conf`,
+		strings.Join(names, "|"),
+		ctl.DataDirectory(),
+	)
+
+	if err != nil {
+		return &pitr.Error{
+			Message: "Could not remove previous recovery settings from postgresql.auto.conf",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	stdout, stderr, err = ctl.runner.Run(
+		`echo "%s" | sudo --user postgres tee --append %s/postgresql.auto.conf`,
+		strings.Join(settings, "\n"),
+		ctl.DataDirectory(),
+	)
+
+	if err != nil {
+		return &pitr.Error{
+			Message: "Could not add recovery settings to postgresql.auto.conf",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return nil
+}
+
+// removeDataFile removes the file with the given name from the data directory, if present
+func (ctl Controller) removeDataFile(name string) *pitr.Error {
+	stdout, stderr, err := ctl.runner.Run("sudo --user postgres rm --force %s/%s", ctl.DataDirectory(), name)
+
+	if err != nil {
+		return &pitr.Error{
+			Message: fmt.Sprintf("Could not remove %s", name),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return nil
+}
+
+// writeDataFile replaces the file with the given name in the data directory with the given lines
+func (ctl Controller) writeDataFile(name string, lines []string) *pitr.Error {
+	stdout, stderr, err := ctl.runner.Run(`echo -n "%s" | sudo --user postgres tee %s/%s`, strings.Join(lines, "\n"), ctl.DataDirectory(), name)
+
+	if err != nil {
+		return &pitr.Error{
+			Message: fmt.Sprintf("Could not write %s", name),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+// Replica describes a standby connected to this cluster
+type Replica struct {
+	ApplicationName string
+	ClientAddress   string
+	State           string
+
+	// Lag is the number of bytes the replica has not replayed yet, or -1 if unknown
+	Lag int64
+}
+
+// IsInRecovery returns true if the cluster is a standby or still recovering
+func (ctl Controller) IsInRecovery() (bool, *pitr.Error) {
+	rows, err := ctl.Query("select pg_is_in_recovery()")
+
+	if err != nil {
+		return false, err
+	}
+
+	if 1 != len(rows) {
+		return false, &pitr.Error{
+			Message: fmt.Sprintf("Expected a single row, but found %d", len(rows)),
+		}
+	}
+
+	return "t" == rows[0][0], nil
+}
+
+// Promote turns a standby into a primary
+func (ctl Controller) Promote() *pitr.Error {
+	stdout, stderr, err := ctl.runner.Run("sudo pg_ctlcluster %s %s promote", ctl.Version, ctl.Name)
+
+	if err != nil {
+		return &pitr.Error{
+			Message: "Could not promote the cluster",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return nil
+}
+
+// Replicas lists the standbys currently connected to this cluster
+func (ctl Controller) Replicas() ([]Replica, *pitr.Error) {
+	wal, err := ctl.walFunctions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := ctl.Query(fmt.Sprintf(
+		"select application_name, coalesce(host(client_addr), ''), state,"+
+			" coalesce(%s(%s, %s), -1)::bigint"+
+			" from pg_stat_replication order by application_name",
+		wal.lsnDiff, wal.currentLSN, wal.replayLSN,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]Replica, len(rows))
+
+	for i, row := range rows {
+		if 4 != len(row) {
+			return nil, &pitr.Error{
+				Message: fmt.Sprintf("Error parsing replica '%v'; expected 4 columns, but found %d", row, len(row)),
+			}
+		}
+
+		lag, parseErr := strconv.ParseInt(row[3], 10, 64)
+
+		if parseErr != nil {
+			return nil, &pitr.Error{
+				Message: parseErr.Error(),
+			}
+		}
+
+		replicas[i] = Replica{
+			ApplicationName: row[0],
+			ClientAddress:   row[1],
+			State:           row[2],
+			Lag:             lag,
+		}
+	}
+
+	return replicas, nil
+}
+
+// WALReceiverStatus returns the status of the WAL receiver of a standby, e.g. "streaming".
+// It is empty if no WAL receiver is running.
+func (ctl Controller) WALReceiverStatus() (string, *pitr.Error) {
+	rows, err := ctl.Query("select status from pg_stat_wal_receiver")
+
+	if err != nil {
+		return "", err
+	}
+
+	if 0 == len(rows) {
+		return "", nil
+	}
+
+	return rows[0][0], nil
+}
+
+// HasReceivedWALPast returns true if a standby has received WAL beyond the given LSN
+func (ctl Controller) HasReceivedWALPast(lsn string) (bool, *pitr.Error) {
+	wal, err := ctl.walFunctions()
+
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := ctl.Query(fmt.Sprintf("select coalesce(%s(%s, '%s') > 0, false)", wal.lsnDiff, wal.lastReceiveLSN, lsn))
+
+	if err != nil {
+		return false, err
+	}
+
+	if 1 != len(rows) {
+		return false, &pitr.Error{
+			Message: fmt.Sprintf("Expected a single row, but found %d", len(rows)),
+		}
+	}
+
+	return "t" == rows[0][0], nil
+}
+
+// ControlData provides the output of pg_controldata, indexed by the name of each entry
+func (ctl Controller) ControlData() (map[string]string, *pitr.Error) {
+	stdout, stderr, err := ctl.runner.Run("sudo --user postgres /usr/lib/postgresql/%s/bin/pg_controldata %s", ctl.Version, ctl.DataDirectory())
+
+	if err != nil {
+		return nil, &pitr.Error{
+			Message: "Could not read the control data of the cluster",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return parseControlData(stdout), nil
+}
+
+func parseControlData(stdout string) map[string]string {
+	result := map[string]string{}
+
+	for _, line := range strings.Split(stdout, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+
+		if 2 != len(parts) {
+			continue
+		}
+
+		result[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return result
+}
@@ -0,0 +1,52 @@
+package cluster_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	clstr "github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+)
+
+var _ = Describe("Replication", func() {
+	var runner *h.FakeRunner
+	var cluster clstr.Controller
+
+	BeforeEach(func() {
+		runner = &h.FakeRunner{}
+		runner.On("from pg_stat_replication", "walreceiver|192.168.71.30|streaming|4096\n", "", nil)
+		runner.On("pg_last_", "t\n", "", nil)
+
+		cluster = clstr.NewController(runner, "11", "main")
+	})
+
+	It("lists the replicas with their lag", func() {
+		Expect(cluster.Replicas()).To(ConsistOf(clstr.Replica{
+			ApplicationName: "walreceiver",
+			ClientAddress:   "192.168.71.30",
+			State:           "streaming",
+			Lag:             4096,
+		}))
+		Expect(runner.CommandsContaining("pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn)")).To(HaveLen(1))
+	})
+
+	It("knows whether a standby received WAL", func() {
+		Expect(cluster.HasReceivedWALPast("0/5000028")).To(BeTrue())
+		Expect(runner.CommandsContaining("pg_wal_lsn_diff(pg_last_wal_receive_lsn(), '0/5000028')")).To(HaveLen(1))
+	})
+
+	Context("before PostgreSQL 10", func() {
+		BeforeEach(func() {
+			cluster = clstr.NewController(runner, "9.6", "main")
+		})
+
+		It("uses the xlog functions for the lag", func() {
+			Expect(cluster.Replicas()).To(HaveLen(1))
+			Expect(runner.CommandsContaining("pg_xlog_location_diff(pg_current_xlog_location(), replay_location)")).To(HaveLen(1))
+		})
+
+		It("uses the xlog functions for the received WAL", func() {
+			Expect(cluster.HasReceivedWALPast("0/5000028")).To(BeTrue())
+			Expect(runner.CommandsContaining("pg_xlog_location_diff(pg_last_xlog_receive_location(), '0/5000028')")).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,235 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+// slotReleaseTimeout limits how long to wait for the consumer of a slot to exit after terminating it
+const slotReleaseTimeout = 10 * time.Second
+
+// ReplicationSlot describes a physical or logical replication slot of the cluster
+type ReplicationSlot struct {
+	Name       string
+	Type       string // physical or logical
+	Plugin     string // output plugin; logical slots only
+	Database   string // logical slots only
+	Active     bool
+	ActivePID  int
+	RestartLSN string
+
+	// RetainedWAL is the number of bytes of WAL kept around for this slot
+	RetainedWAL int64
+}
+
+// walFunctions names the functions and columns dealing with WAL locations, which PostgreSQL 10 renamed
+// from xlog and location to wal and lsn
+type walFunctions struct {
+	currentLSN     string
+	lastReceiveLSN string
+	lsnDiff        string
+	replayLSN      string
+}
+
+func (ctl Controller) walFunctions() (walFunctions, *pitr.Error) {
+	version, parseErr := pitr.ParseVersion(ctl.Version)
+
+	if parseErr != nil {
+		return walFunctions{}, &pitr.Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	if version.Compare(pitr.FirstVersionWithPgWal) < 0 {
+		return walFunctions{
+			currentLSN:     "pg_current_xlog_location()",
+			lastReceiveLSN: "pg_last_xlog_receive_location()",
+			lsnDiff:        "pg_xlog_location_diff",
+			replayLSN:      "replay_location",
+		}, nil
+	}
+
+	return walFunctions{
+		currentLSN:     "pg_current_wal_lsn()",
+		lastReceiveLSN: "pg_last_wal_receive_lsn()",
+		lsnDiff:        "pg_wal_lsn_diff",
+		replayLSN:      "replay_lsn",
+	}, nil
+}
+
+// ReplicationSlots lists all replication slots of the cluster, including the amount of WAL each one retains
+func (ctl Controller) ReplicationSlots() ([]ReplicationSlot, *pitr.Error) {
+	wal, err := ctl.walFunctions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := ctl.Query(fmt.Sprintf(
+		"select slot_name, slot_type, coalesce(plugin, ''), coalesce(database, ''), active, coalesce(active_pid, 0), coalesce(restart_lsn::text, ''),"+
+			" coalesce(%s(case when pg_is_in_recovery() then %s else %s end, restart_lsn), 0)::bigint"+
+			" from pg_replication_slots order by slot_name",
+		wal.lsnDiff, wal.lastReceiveLSN, wal.currentLSN,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]ReplicationSlot, len(rows))
+
+	for i, row := range rows {
+		slot, parseErr := parseReplicationSlot(row)
+
+		if parseErr != nil {
+			return nil, &pitr.Error{
+				Message: parseErr.Error(),
+			}
+		}
+
+		slots[i] = *slot
+	}
+
+	return slots, nil
+}
+
+// ReplicationSlot returns the replication slot with the given name, or nil if there is no such slot
+func (ctl Controller) ReplicationSlot(name string) (*ReplicationSlot, *pitr.Error) {
+	slots, err := ctl.ReplicationSlots()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, slot := range slots {
+		if slot.Name == name {
+			return &slot, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// CreatePhysicalReplicationSlot creates a physical replication slot that reserves WAL immediately
+func (ctl Controller) CreatePhysicalReplicationSlot(name string) *pitr.Error {
+	if !isIdentifier(name) {
+		return &pitr.Error{
+			Message: fmt.Sprintf("Invalid replication slot name '%s'", name),
+		}
+	}
+
+	_, err := ctl.Query(fmt.Sprintf("select pg_create_physical_replication_slot('%s', true)", name))
+
+	return err
+}
+
+// CreateLogicalReplicationSlot creates a logical replication slot in the given database, using the given output plugin
+func (ctl Controller) CreateLogicalReplicationSlot(name, database, plugin string) *pitr.Error {
+	for _, identifier := range []string{name, database, plugin} {
+		if !isIdentifier(identifier) {
+			return &pitr.Error{
+				Message: fmt.Sprintf("Invalid identifier '%s' for a logical replication slot", identifier),
+			}
+		}
+	}
+
+	_, err := ctl.QueryDatabase(database, fmt.Sprintf("select pg_create_logical_replication_slot('%s', '%s')", name, plugin))
+
+	return err
+}
+
+// DropReplicationSlot drops the replication slot with the given name.
+// An active slot is only dropped if force is true; its consumer is terminated first.
+func (ctl Controller) DropReplicationSlot(name string, force bool) *pitr.Error {
+	if !isIdentifier(name) {
+		return &pitr.Error{
+			Message: fmt.Sprintf("Invalid replication slot name '%s'", name),
+		}
+	}
+
+	slot, err := ctl.ReplicationSlot(name)
+
+	if err != nil {
+		return err
+	}
+
+	if slot == nil {
+		return &pitr.Error{
+			Message: fmt.Sprintf("Replication slot '%s' does not exist", name),
+		}
+	}
+
+	if slot.Active {
+		if !force {
+			return &pitr.Error{
+				Message: fmt.Sprintf("Replication slot '%s' is in use by process %d; refusing to drop it", name, slot.ActivePID),
+			}
+		}
+
+		_, err = ctl.Query(fmt.Sprintf("select pg_terminate_backend(%d)", slot.ActivePID))
+
+		if err != nil {
+			return err
+		}
+
+		// pg_terminate_backend only signals the consumer; the slot stays active until it has exited
+		gone := false
+
+		err = ctl.waitFor(slotReleaseTimeout, func() (bool, *pitr.Error) {
+			slot, err := ctl.ReplicationSlot(name)
+
+			if err != nil {
+				return false, err
+			}
+
+			gone = slot == nil
+
+			return gone || !slot.Active, nil
+		}, fmt.Sprintf("Replication slot '%s' is still active %s after terminating process %d", name, slotReleaseTimeout, slot.ActivePID))
+
+		if err != nil {
+			return err
+		}
+
+		// a temporary slot is dropped together with its consumer
+		if gone {
+			return nil
+		}
+	}
+
+	_, err = ctl.Query(fmt.Sprintf("select pg_drop_replication_slot('%s')", name))
+
+	return err
+}
+
+func parseReplicationSlot(row []string) (*ReplicationSlot, error) {
+	if 8 != len(row) {
+		return nil, fmt.Errorf("Error parsing replication slot '%v'; expected 8 columns, but found %d", row, len(row))
+	}
+
+	activePID, err := strconv.Atoi(row[5])
+
+	if err != nil {
+		return nil, err
+	}
+
+	retainedWAL, err := strconv.ParseInt(row[7], 10, 64)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplicationSlot{
+		Name:        row[0],
+		Type:        row[1],
+		Plugin:      row[2],
+		Database:    row[3],
+		Active:      "t" == row[4],
+		ActivePID:   activePID,
+		RestartLSN:  row[6],
+		RetainedWAL: retainedWAL,
+	}, nil
+}
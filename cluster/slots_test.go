@@ -0,0 +1,137 @@
+package cluster_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	clstr "github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+)
+
+var _ = Describe("Replication slots", func() {
+	var runner *h.FakeRunner
+	var cluster clstr.Controller
+
+	BeforeEach(func() {
+		runner = &h.FakeRunner{}
+		runner.On("from pg_replication_slots", "standby1|physical|||t|4711|0/3000060|16777216\ncdc|logical|pgoutput|sandbox|f|0|0/2000028|33554432\n", "", nil)
+
+		cluster = clstr.NewController(runner, "11", "main")
+	})
+
+	It("lists all slots", func() {
+		slots, err := cluster.ReplicationSlots()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(slots).To(HaveLen(2))
+	})
+
+	It("knows about a physical slot", func() {
+		slot, err := cluster.ReplicationSlot("standby1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(slot.Type).To(Equal("physical"))
+		Expect(slot.Active).To(BeTrue())
+		Expect(slot.ActivePID).To(Equal(4711))
+		Expect(slot.RestartLSN).To(Equal("0/3000060"))
+		Expect(slot.RetainedWAL).To(Equal(int64(16777216)))
+	})
+
+	It("knows about a logical slot", func() {
+		slot, err := cluster.ReplicationSlot("cdc")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(slot.Type).To(Equal("logical"))
+		Expect(slot.Plugin).To(Equal("pgoutput"))
+		Expect(slot.Database).To(Equal("sandbox"))
+		Expect(slot.Active).To(BeFalse())
+	})
+
+	It("does not find an unknown slot", func() {
+		slot, err := cluster.ReplicationSlot("unknown")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(slot).To(BeNil())
+	})
+
+	It("creates a physical slot", func() {
+		err := cluster.CreatePhysicalReplicationSlot("standby2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("pg_create_physical_replication_slot('standby2', true)")).To(HaveLen(1))
+	})
+
+	It("creates a logical slot in the given database", func() {
+		err := cluster.CreateLogicalReplicationSlot("cdc2", "sandbox", "test_decoding")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("--dbname=sandbox")).To(HaveLen(1))
+		Expect(runner.CommandsContaining("pg_create_logical_replication_slot('cdc2', 'test_decoding')")).To(HaveLen(1))
+	})
+
+	It("rejects invalid slot names", func() {
+		err := cluster.CreatePhysicalReplicationSlot("'; drop table foo; --")
+		Expect(err).To(HaveOccurred())
+		Expect(runner.Commands).To(BeEmpty())
+	})
+
+	It("drops an inactive slot", func() {
+		err := cluster.DropReplicationSlot("cdc", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("pg_drop_replication_slot('cdc')")).To(HaveLen(1))
+	})
+
+	It("refuses to drop an active slot", func() {
+		err := cluster.DropReplicationSlot("standby1", false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Message).To(ContainSubstring("in use by process 4711"))
+		Expect(runner.CommandsContaining("pg_drop_replication_slot")).To(BeEmpty())
+	})
+
+	Context("force-dropping an active slot", func() {
+		const active = "standby1|physical|||t|4711|0/3000060|16777216\n"
+		const released = "standby1|physical|||f|0|0/3000060|16777216\n"
+
+		BeforeEach(func() {
+			cluster.PollInterval = 0
+			runner.On("from pg_replication_slots", released, "", nil)
+			runner.Once("from pg_replication_slots", active, "", nil) // still active at the first poll
+			runner.Once("from pg_replication_slots", active, "", nil) // active when looking it up
+		})
+
+		It("terminates the consumer and waits until it has let go of the slot", func() {
+			err := cluster.DropReplicationSlot("standby1", true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_terminate_backend(4711)")).To(HaveLen(1))
+			Expect(runner.CommandsContaining("from pg_replication_slots")).To(HaveLen(3))
+			Expect(runner.Commands[len(runner.Commands)-1]).To(ContainSubstring("pg_drop_replication_slot('standby1')"))
+		})
+
+		Context("the slot was temporary", func() {
+			BeforeEach(func() {
+				runner.On("from pg_replication_slots", "", "", nil)
+				runner.Once("from pg_replication_slots", active, "", nil)
+			})
+
+			It("does not drop it again", func() {
+				err := cluster.DropReplicationSlot("standby1", true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("pg_drop_replication_slot")).To(BeEmpty())
+			})
+		})
+	})
+
+	It("fails to drop a missing slot", func() {
+		err := cluster.DropReplicationSlot("unknown", false)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("measures the retained WAL with the functions of PostgreSQL 10", func() {
+		cluster.ReplicationSlots()
+		Expect(runner.CommandsContaining("pg_wal_lsn_diff(case when pg_is_in_recovery() then pg_last_wal_receive_lsn() else pg_current_wal_lsn() end, restart_lsn)")).To(HaveLen(1))
+	})
+
+	Context("before PostgreSQL 10", func() {
+		BeforeEach(func() {
+			cluster = clstr.NewController(runner, "9.6", "main")
+		})
+
+		It("measures the retained WAL with the xlog functions", func() {
+			Expect(cluster.ReplicationSlots()).To(HaveLen(2))
+			Expect(runner.CommandsContaining("pg_xlog_location_diff(case when pg_is_in_recovery() then pg_last_xlog_receive_location() else pg_current_xlog_location() end, restart_lsn)")).To(HaveLen(1))
+		})
+	})
+})
@@ -3,7 +3,10 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 
+	pitr "github.com/suhlig/postgres-pitr"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -30,10 +33,23 @@ type Config struct {
 	}
 
 	PgBackRest struct {
-		Stanza string
+		Stanza      string
+		ArchiveType string `yaml:"archive_type"`
+		S3Bucket    string `yaml:"s3_bucket"`
+		CipherPass  string `yaml:"cipher_pass"`
+
+		// CipherPassFile is read instead of the plaintext CipherPass, e.g. a file provided by a secret store
+		CipherPassFile string `yaml:"cipher_pass_file"`
+
+		// Repo is the key of the repository in pgbackrest.conf; 0 means 1. Rotating the cipher pass adds a new one.
+		Repo int `yaml:"repo"`
+
+		// RepoPath overrides the default location of the repository
+		RepoPath string `yaml:"repo_path"`
 	}
 
 	Minio struct {
+		Domain    string
 		Host      string
 		Port      int
 		UseSSL    bool   `yaml:"use_ssl"`
@@ -48,6 +64,7 @@ func (cfg Config) FromFile(path string) (Config, error) {
 	cfg.Standby.Port = 5432
 	cfg.Minio.Port = 443
 	cfg.Minio.UseSSL = true
+	cfg.PgBackRest.ArchiveType = "local"
 
 	yamlFile, err := ioutil.ReadFile(path)
 
@@ -70,6 +87,62 @@ func (cfg Config) StandbyDatabaseURL() (string, error) {
 	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s", cfg.Standby.User, cfg.Standby.Password, cfg.Standby.Host, cfg.Standby.Port, cfg.Standby.Name), nil
 }
 
+// MasterVersion returns the parsed server version of the master cluster
+func (cfg Config) MasterVersion() (pitr.Version, error) {
+	return pitr.ParseVersion(cfg.Master.Version)
+}
+
+// StandbyVersion returns the parsed server version of the standby cluster
+func (cfg Config) StandbyVersion() (pitr.Version, error) {
+	return pitr.ParseVersion(cfg.Standby.Version)
+}
+
+// RepoKey returns the key of the pgbackrest repository
+func (cfg Config) RepoKey() int {
+	if 0 == cfg.PgBackRest.Repo {
+		return 1
+	}
+
+	return cfg.PgBackRest.Repo
+}
+
+// CipherPassEnv returns the environment variable that overrides the cipher pass of the repository. It is the
+// same variable pgbackrest itself reads, e.g. PGBACKREST_REPO1_CIPHER_PASS.
+func (cfg Config) CipherPassEnv() string {
+	return fmt.Sprintf("PGBACKREST_REPO%d_CIPHER_PASS", cfg.RepoKey())
+}
+
+// RepoCipherPass returns the cipher pass of the pgbackrest repository. The environment variable CipherPassEnv
+// takes precedence over cipher_pass_file, which takes precedence over cipher_pass. An empty cipher_pass means
+// that the repository is not encrypted; an empty environment variable or file is an error.
+func (cfg Config) RepoCipherPass() (string, error) {
+	if pass, found := os.LookupEnv(cfg.CipherPassEnv()); found {
+		if "" == pass {
+			return "", fmt.Errorf("Environment variable %s is empty", cfg.CipherPassEnv())
+		}
+
+		return pass, nil
+	}
+
+	if "" != cfg.PgBackRest.CipherPassFile {
+		content, err := ioutil.ReadFile(cfg.PgBackRest.CipherPassFile)
+
+		if err != nil {
+			return "", err
+		}
+
+		pass := strings.TrimRight(string(content), "\r\n")
+
+		if "" == pass {
+			return "", fmt.Errorf("Cipher pass file %s is empty", cfg.PgBackRest.CipherPassFile)
+		}
+
+		return pass, nil
+	}
+
+	return cfg.PgBackRest.CipherPass, nil
+}
+
 // BlobstoreURL returns the URL to access the database on the standby node
 func (cfg Config) BlobstoreURL() (string, error) {
 	if cfg.Minio.UseSSL {
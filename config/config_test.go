@@ -1,6 +1,9 @@
 package config_test
 
 import (
+	"io/ioutil"
+	"os"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -23,6 +26,12 @@ var _ = Describe("Config", func() {
 				Expect(config.Master.Version).To(Equal("11"))
 			})
 
+			It("has the parsed server version", func() {
+				version, err := config.MasterVersion()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(version.MajorString()).To(Equal("11"))
+			})
+
 			It("has the configured master cluster name", func() {
 				Expect(config.Master.ClusterName).ToNot(BeEmpty())
 				Expect(config.Master.ClusterName).To(Equal("main"))
@@ -39,6 +48,12 @@ var _ = Describe("Config", func() {
 				Expect(config.Standby.Version).To(Equal("11"))
 			})
 
+			It("has the parsed server version", func() {
+				version, err := config.StandbyVersion()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(version.MajorString()).To(Equal("11"))
+			})
+
 			It("has the configured standby cluster name", func() {
 				Expect(config.Standby.ClusterName).ToNot(BeEmpty())
 				Expect(config.Standby.ClusterName).To(Equal("main"))
@@ -54,6 +69,74 @@ var _ = Describe("Config", func() {
 				Expect(config.PgBackRest.Stanza).ToNot(BeEmpty())
 				Expect(config.PgBackRest.Stanza).To(Equal("pitr"))
 			})
+
+			It("has the archive type", func() {
+				Expect(config.PgBackRest.ArchiveType).To(Equal("minio"))
+			})
+
+			It("has the bucket", func() {
+				Expect(config.PgBackRest.S3Bucket).To(Equal("postgres-backup"))
+			})
+
+			It("has the cipher pass", func() {
+				Expect(config.PgBackRest.CipherPass).ToNot(BeEmpty())
+			})
+
+			Context("resolving the cipher pass", func() {
+				var passFile string
+
+				BeforeEach(func() {
+					file, err := ioutil.TempFile("", "cipher-pass")
+					Expect(err).NotTo(HaveOccurred())
+					_, err = file.WriteString("from-file\n")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(file.Close()).To(Succeed())
+					passFile = file.Name()
+
+					// FromFile keeps what earlier specs set
+					config.PgBackRest.CipherPassFile = ""
+					config.PgBackRest.Repo = 0
+				})
+
+				AfterEach(func() {
+					os.Unsetenv("PGBACKREST_REPO1_CIPHER_PASS")
+					os.Remove(passFile)
+				})
+
+				It("uses the plaintext value by default", func() {
+					Expect(config.RepoCipherPass()).To(Equal(config.PgBackRest.CipherPass))
+				})
+
+				It("prefers the file over the plaintext value", func() {
+					config.PgBackRest.CipherPassFile = passFile
+					Expect(config.RepoCipherPass()).To(Equal("from-file"))
+				})
+
+				It("prefers the environment over the file", func() {
+					config.PgBackRest.CipherPassFile = passFile
+					os.Setenv("PGBACKREST_REPO1_CIPHER_PASS", "from-env")
+					Expect(config.RepoCipherPass()).To(Equal("from-env"))
+				})
+
+				It("reads the variable of the configured repository", func() {
+					config.PgBackRest.Repo = 2
+					os.Setenv("PGBACKREST_REPO1_CIPHER_PASS", "from-env")
+					Expect(config.RepoCipherPass()).To(Equal(config.PgBackRest.CipherPass))
+				})
+
+				It("fails if the environment variable is empty", func() {
+					config.PgBackRest.CipherPassFile = passFile
+					os.Setenv("PGBACKREST_REPO1_CIPHER_PASS", "")
+					_, err := config.RepoCipherPass()
+					Expect(err).To(HaveOccurred())
+				})
+
+				It("fails if the file cannot be read", func() {
+					config.PgBackRest.CipherPassFile = passFile + ".missing"
+					_, err := config.RepoCipherPass()
+					Expect(err).To(HaveOccurred())
+				})
+			})
 		})
 
 		Context("for minio", func() {
@@ -65,6 +148,10 @@ var _ = Describe("Config", func() {
 				Expect(config.Minio.SecretKey).ToNot(BeEmpty())
 			})
 
+			It("has a domain", func() {
+				Expect(config.Minio.Domain).To(Equal("minio.local"))
+			})
+
 			It("has a URL", func() {
 				Expect(config.BlobstoreURL()).To(Equal("http://192.168.71.20:80/"))
 			})
@@ -0,0 +1,144 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FakeRunner records the commands it is asked to run and replies with canned
+// responses instead of executing anything
+type FakeRunner struct {
+	Commands []string
+
+	// Inputs has what was passed on stdin, by the index of the command in Commands
+	Inputs map[int]string
+
+	responses []fakeResponse
+	delays    map[string]time.Duration
+}
+
+type fakeResponse struct {
+	substring      string
+	stdout, stderr string
+	err            error
+	once, used     bool
+}
+
+// On registers a response for all commands containing the given substring.
+// Responses registered later take precedence over earlier ones.
+func (runner *FakeRunner) On(substring, stdout, stderr string, err error) *FakeRunner {
+	runner.responses = append(runner.responses, fakeResponse{
+		substring: substring,
+		stdout:    stdout,
+		stderr:    stderr,
+		err:       err,
+	})
+
+	return runner
+}
+
+// Once registers a response that is used for the next matching command only.
+// Afterwards, earlier registrations apply again.
+func (runner *FakeRunner) Once(substring, stdout, stderr string, err error) *FakeRunner {
+	runner.responses = append(runner.responses, fakeResponse{
+		substring: substring,
+		stdout:    stdout,
+		stderr:    stderr,
+		err:       err,
+		once:      true,
+	})
+
+	return runner
+}
+
+// Delay makes all commands containing the given substring take at least the given duration
+func (runner *FakeRunner) Delay(substring string, duration time.Duration) *FakeRunner {
+	if runner.delays == nil {
+		runner.delays = make(map[string]time.Duration)
+	}
+
+	runner.delays[substring] = duration
+
+	return runner
+}
+
+// RunWithInput records the input like Run records the command
+func (runner *FakeRunner) RunWithInput(input, command string, args ...interface{}) (string, string, error) {
+	if runner.Inputs == nil {
+		runner.Inputs = make(map[int]string)
+	}
+
+	runner.Inputs[len(runner.Commands)] = input
+
+	return runner.Run(command, args...)
+}
+
+// Run records the command, with args interpolated, and replies with the most
+// recently registered matching response. Unknown commands succeed without output.
+func (runner *FakeRunner) Run(command string, args ...interface{}) (string, string, error) {
+	cmd := fmt.Sprintf(command, args...)
+	runner.Commands = append(runner.Commands, cmd)
+
+	for substring, duration := range runner.delays {
+		if strings.Contains(cmd, substring) {
+			time.Sleep(duration)
+		}
+	}
+
+	for i := len(runner.responses) - 1; i >= 0; i-- {
+		response := &runner.responses[i]
+
+		if response.used || !strings.Contains(cmd, response.substring) {
+			continue
+		}
+
+		if response.once {
+			response.used = true
+		}
+
+		return response.stdout, response.stderr, response.err
+	}
+
+	return "", "", nil
+}
+
+// CommandsContaining returns all recorded commands containing the given substring
+func (runner *FakeRunner) CommandsContaining(substring string) []string {
+	result := []string{}
+
+	for _, cmd := range runner.Commands {
+		if strings.Contains(cmd, substring) {
+			result = append(result, cmd)
+		}
+	}
+
+	return result
+}
+
+// InputsOf returns what was passed on stdin to all recorded commands containing the given substring
+func (runner *FakeRunner) InputsOf(substring string) []string {
+	result := []string{}
+
+	for i, cmd := range runner.Commands {
+		if input, found := runner.Inputs[i]; found && strings.Contains(cmd, substring) {
+			result = append(result, input)
+		}
+	}
+
+	return result
+}
+
+// ExitError mimics the error returned by a command exiting with a non-zero status
+type ExitError struct {
+	Status int
+}
+
+func (e ExitError) Error() string {
+	return fmt.Sprintf("Process exited with status %d", e.Status)
+}
+
+// ExitStatus returns the exit status of the failed command
+func (e ExitError) ExitStatus() int {
+	return e.Status
+}
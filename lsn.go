@@ -0,0 +1,38 @@
+package postgres_pitr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LSN is a log sequence number, i.e. a position in the WAL like 16/B374D848
+type LSN uint64
+
+// ParseLSN parses an LSN in the format used by PostgreSQL and pg_waldump, e.g. "16/B374D848"
+func ParseLSN(lsn string) (LSN, error) {
+	parts := strings.Split(strings.TrimSpace(lsn), "/")
+
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Error parsing LSN '%s'; expected two hexadecimal numbers separated by '/'", lsn)
+	}
+
+	high, err := strconv.ParseUint(parts[0], 16, 32)
+
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing LSN '%s'", lsn)
+	}
+
+	low, err := strconv.ParseUint(parts[1], 16, 32)
+
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing LSN '%s'", lsn)
+	}
+
+	return LSN(high<<32 | low), nil
+}
+
+// String formats the LSN like PostgreSQL does, e.g. "16/B374D848"
+func (lsn LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint64(lsn)>>32, uint64(lsn)&0xFFFFFFFF)
+}
@@ -0,0 +1,51 @@
+package postgres_pitr_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+var _ = Describe("LSN", func() {
+	parse := func(s string) pitr.LSN {
+		lsn, err := pitr.ParseLSN(s)
+		Expect(err).NotTo(HaveOccurred())
+		return lsn
+	}
+
+	It("parses an LSN", func() {
+		Expect(parse("16/B374D848")).To(Equal(pitr.LSN(0x16B374D848)))
+	})
+
+	It("parses lower case", func() {
+		Expect(parse("16/b374d848")).To(Equal(parse("16/B374D848")))
+	})
+
+	It("formats an LSN", func() {
+		Expect(pitr.LSN(0x16B374D848).String()).To(Equal("16/B374D848"))
+		Expect(pitr.LSN(0x3000060).String()).To(Equal("0/3000060"))
+	})
+
+	It("survives a round trip", func() {
+		Expect(parse("FFFFFFFF/FFFFFFFF").String()).To(Equal("FFFFFFFF/FFFFFFFF"))
+	})
+
+	It("orders by position", func() {
+		Expect(parse("1/0") > parse("0/FFFFFFFF")).To(BeTrue())
+	})
+
+	It("requires a slash", func() {
+		_, err := pitr.ParseLSN("16B374D848")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires hexadecimal numbers", func() {
+		_, err := pitr.ParseLSN("16/XYZ")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects parts that are too large", func() {
+		_, err := pitr.ParseLSN("100000000/0")
+		Expect(err).To(HaveOccurred())
+	})
+})
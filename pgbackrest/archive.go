@@ -0,0 +1,261 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/suhlig/postgres-pitr/cluster"
+)
+
+// defaultSpoolPath is where pgbackrest queues WAL for asynchronous archiving unless spool-path is set
+const defaultSpoolPath = "/var/spool/pgbackrest"
+
+// segmentsPerLogFile is the number of WAL segments per log file, assuming the default segment size of 16 MB
+const segmentsPerLogFile = 0x100
+
+// ArchiveDiagnosis tells how far WAL archiving is behind and why
+type ArchiveDiagnosis struct {
+	Archiver cluster.ArchiverStats
+
+	// ArchiveMax is the most recent segment in the repository, according to pgbackrest info
+	ArchiveMax string
+
+	// Ready lists the segments PostgreSQL is waiting to archive
+	Ready []string
+
+	// Lag is the number of completed segments that are not in the repository yet
+	Lag int64
+
+	// Async tells whether archive-push queues segments in the spool directory
+	Async     bool
+	SpoolPath string
+
+	// SpoolErrors are the errors pgbackrest left in the spool directory for segments it could not push
+	SpoolErrors []string
+
+	// Causes are the probable reasons why archiving stalls; empty if archiving works
+	Causes []string
+}
+
+// DiagnoseArchive compares the archiver statistics of the cluster with what pgbackrest has in the repository
+// for the given stanza, inspects the spool directory when archiving asynchronously, and names probable causes
+// if archiving lags behind.
+func (ctl Controller) DiagnoseArchive(stanza string) (ArchiveDiagnosis, *Error) {
+	diagnosis := ArchiveDiagnosis{}
+
+	stats, pitrErr := ctl.cluster.ArchiverStats()
+
+	if pitrErr != nil {
+		return diagnosis, fromPitrError(pitrErr)
+	}
+
+	diagnosis.Archiver = *stats
+
+	diagnosis.Ready, pitrErr = ctl.cluster.ReadyWAL()
+
+	if pitrErr != nil {
+		return diagnosis, fromPitrError(pitrErr)
+	}
+
+	archiveMode, pitrErr := ctl.cluster.Setting("archive_mode")
+
+	if pitrErr != nil {
+		return diagnosis, fromPitrError(pitrErr)
+	}
+
+	archiveCommand, pitrErr := ctl.cluster.Setting("archive_command")
+
+	if pitrErr != nil {
+		return diagnosis, fromPitrError(pitrErr)
+	}
+
+	if "off" == archiveMode {
+		diagnosis.Causes = append(diagnosis.Causes, "archive_mode is off")
+	}
+
+	if !strings.Contains(archiveCommand, "archive-push") || !strings.Contains(archiveCommand, "--stanza="+stanza) {
+		diagnosis.Causes = append(diagnosis.Causes, fmt.Sprintf("archive_command '%s' does not push to stanza %s", archiveCommand, stanza))
+	}
+
+	info, err := ctl.stanzaInfo(stanza)
+
+	if err != nil {
+		return diagnosis, err
+	}
+
+	if 0 != info.Status.Code {
+		diagnosis.Causes = append(diagnosis.Causes, fmt.Sprintf("stanza %s is not healthy: %s", stanza, info.Status.Message))
+	}
+
+	diagnosis.ArchiveMax = currentArchiveMax(*info)
+
+	if "" == diagnosis.ArchiveMax {
+		diagnosis.Causes = append(diagnosis.Causes, fmt.Sprintf("the repository has no WAL of the current database of stanza %s", stanza))
+	}
+
+	diagnosis.Lag = segmentDistance(diagnosis.ArchiveMax, stats.CurrentWAL) - 1
+
+	if diagnosis.Lag < 0 {
+		diagnosis.Lag = 0
+	}
+
+	if "" != diagnosis.ArchiveMax && "" != stats.LastArchivedWAL && segmentDistance(diagnosis.ArchiveMax, stats.LastArchivedWAL) > 0 {
+		diagnosis.Causes = append(diagnosis.Causes, fmt.Sprintf("PostgreSQL archived %s, but the repository ends at %s; the WAL may be pushed to another repository or stanza", stats.LastArchivedWAL, diagnosis.ArchiveMax))
+	}
+
+	err = ctl.inspectSpool(stanza, &diagnosis)
+
+	if err != nil {
+		return diagnosis, err
+	}
+
+	if stats.IsFailing() {
+		cause := fmt.Sprintf("archive_command has been failing for %s since %s", stats.LastFailedWAL, stats.LastFailedTime.Format("2006-01-02 15:04:05 MST"))
+
+		if 0 < len(diagnosis.SpoolErrors) {
+			cause += ": " + diagnosis.SpoolErrors[0]
+		}
+
+		diagnosis.Causes = append(diagnosis.Causes, cause)
+	} else if 1 < len(diagnosis.Ready) {
+		diagnosis.Causes = append(diagnosis.Causes, fmt.Sprintf("archiving is slower than WAL is written; %d segments are waiting", len(diagnosis.Ready)))
+	}
+
+	return diagnosis, nil
+}
+
+// Healthy returns true if no reasons for stalled archiving were found
+func (diagnosis ArchiveDiagnosis) Healthy() bool {
+	return 0 == len(diagnosis.Causes)
+}
+
+// inspectSpool checks the spool directory if archive-push works asynchronously
+func (ctl Controller) inspectSpool(stanza string, diagnosis *ArchiveDiagnosis) *Error {
+	conf, err := ctl.DeployedConf()
+
+	if err != nil {
+		return err
+	}
+
+	diagnosis.Async = "y" == confValue(conf, stanza, "archive-push", "archive-async")
+
+	if !diagnosis.Async {
+		return nil
+	}
+
+	diagnosis.SpoolPath = confValue(conf, stanza, "archive-push", "spool-path")
+
+	if "" == diagnosis.SpoolPath {
+		diagnosis.SpoolPath = defaultSpoolPath
+	}
+
+	_, _, runErr := ctl.runner.Run("sudo --user postgres test -w %s", diagnosis.SpoolPath)
+
+	if runErr != nil {
+		diagnosis.Causes = append(diagnosis.Causes, fmt.Sprintf("spool path %s is missing or not writable", diagnosis.SpoolPath))
+		return nil
+	}
+
+	stdout, _, runErr := ctl.runner.Run("sudo --user postgres find %s/archive/%s/out -name '*.error' -exec cat {} +", diagnosis.SpoolPath, stanza)
+
+	if runErr != nil {
+		return nil // the queue does not exist until the first segment was pushed
+	}
+
+	diagnosis.SpoolErrors = parseSpoolErrors(stdout)
+
+	return nil
+}
+
+// parseSpoolErrors parses the concatenated error files of the spool directory. Each file has
+// the error code on its first line, followed by the message.
+func parseSpoolErrors(content string) []string {
+	errors := make([]string, 0)
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+
+		if "" == line {
+			continue
+		}
+
+		if _, err := strconv.Atoi(line); err == nil {
+			errors = append(errors, fmt.Sprintf("[%s]", line))
+			continue
+		}
+
+		if 0 == len(errors) {
+			errors = append(errors, line)
+			continue
+		}
+
+		errors[len(errors)-1] += " " + line
+	}
+
+	return errors
+}
+
+// confValue returns the value of an option as pgbackrest resolves it for the given stanza and command
+func confValue(conf Conf, stanza, command, key string) string {
+	for _, section := range []string{CommandSection(stanza, command), stanza, CommandSection(GlobalSection, command), GlobalSection} {
+		if value, found := conf.Get(section, key); found {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// currentArchiveMax returns the most recent segment archived for the current database of the stanza
+func currentArchiveMax(info Info) string {
+	database := info.CurrentDatabase()
+
+	if database == nil {
+		return ""
+	}
+
+	max := ""
+
+	for _, archive := range info.Archives {
+		if archive.Database.ID == database.ID && archive.Max > max {
+			max = archive.Max
+		}
+	}
+
+	return max
+}
+
+// segmentDistance returns how many segments the second WAL file name is ahead of the first one,
+// ignoring the timeline. It is 0 if either name is not a valid WAL file name.
+func segmentDistance(from, to string) int64 {
+	fromSegment, fromOK := segmentNumber(from)
+	toSegment, toOK := segmentNumber(to)
+
+	if !fromOK || !toOK {
+		return 0
+	}
+
+	return toSegment - fromSegment
+}
+
+// segmentNumber returns the position of the WAL file with the given name, like 000000010000000000000008
+func segmentNumber(walFile string) (int64, bool) {
+	if 24 != len(walFile) {
+		return 0, false
+	}
+
+	log, err := strconv.ParseInt(walFile[8:16], 16, 64)
+
+	if err != nil {
+		return 0, false
+	}
+
+	segment, err := strconv.ParseInt(walFile[16:24], 16, 64)
+
+	if err != nil {
+		return 0, false
+	}
+
+	return log*segmentsPerLogFile + segment, true
+}
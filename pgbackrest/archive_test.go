@@ -0,0 +1,143 @@
+package pgbackrest_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Archive diagnostics", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var diagnosis pgbackrest.ArchiveDiagnosis
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "info-2.19.json")
+		runner.On("cat /etc/pgbackrest.conf", deployedConf, "", nil)
+		runner.On("from pg_stat_archiver", "12|00000001000000000000000C|1574763700|0||0|00000001000000000000000D\n", "", nil)
+		runner.On("current_setting('archive_mode')", "on\n", "", nil)
+		runner.On("current_setting('archive_command')", "pgbackrest --stanza=pitr archive-push %p\n", "", nil)
+	})
+
+	JustBeforeEach(func() {
+		diagnosis, err = ctl.DiagnoseArchive("pitr")
+	})
+
+	Context("archiving works", func() {
+		It("is healthy", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diagnosis.Healthy()).To(BeTrue(), strings.Join(diagnosis.Causes, "\n"))
+			Expect(diagnosis.Lag).To(BeZero())
+		})
+
+		It("has the archiver statistics", func() {
+			Expect(diagnosis.Archiver.ArchivedCount).To(Equal(int64(12)))
+			Expect(diagnosis.Archiver.LastArchivedWAL).To(Equal("00000001000000000000000C"))
+			Expect(diagnosis.Archiver.LastArchivedTime.Unix()).To(Equal(int64(1574763700)))
+			Expect(diagnosis.Archiver.LastFailedTime.IsZero()).To(BeTrue())
+			Expect(diagnosis.Archiver.CurrentWAL).To(Equal("00000001000000000000000D"))
+		})
+
+		It("has the most recent segment in the repository", func() {
+			Expect(diagnosis.ArchiveMax).To(Equal("00000001000000000000000C"))
+		})
+
+		It("looks for segments waiting to be archived", func() {
+			Expect(runner.CommandsContaining("/var/lib/postgresql/11/main/pg_wal/archive_status")).To(HaveLen(1))
+		})
+
+		It("does not look at the spool directory", func() {
+			Expect(diagnosis.Async).To(BeFalse())
+			Expect(runner.CommandsContaining("/var/spool/pgbackrest")).To(BeEmpty())
+		})
+	})
+
+	Context("archive_command fails", func() {
+		BeforeEach(func() {
+			runner.On("from pg_stat_archiver", "12|00000001000000000000000C|1574763700|7|00000001000000000000000D|1574764000|000000010000000000000011\n", "", nil)
+			runner.On("archive_status", "00000001000000000000000D.ready\n00000001000000000000000E.ready\n00000001000000000000000F.ready\n000000010000000000000010.ready\n", "", nil)
+		})
+
+		It("reports the lag", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diagnosis.Lag).To(Equal(int64(4)))
+			Expect(diagnosis.Ready).To(HaveLen(4))
+			Expect(diagnosis.Ready[0]).To(Equal("00000001000000000000000D"))
+		})
+
+		It("names the failing segment", func() {
+			Expect(diagnosis.Healthy()).To(BeFalse())
+			Expect(diagnosis.Causes).To(ConsistOf(ContainSubstring("failing for 00000001000000000000000D")))
+		})
+
+		Context("asynchronously", func() {
+			BeforeEach(func() {
+				runner.On("cat /etc/pgbackrest.conf", deployedConf+"archive-async=y\nspool-path=/var/spool/backrest\n", "", nil)
+				runner.On("-name '*.error'", "103\nunable to find a valid repository:\nrepo1: [FileMissingError] unable to load info file\n", "", nil)
+			})
+
+			It("inspects the spool directory", func() {
+				Expect(diagnosis.Async).To(BeTrue())
+				Expect(diagnosis.SpoolPath).To(Equal("/var/spool/backrest"))
+				Expect(runner.CommandsContaining("find /var/spool/backrest/archive/pitr/out")).To(HaveLen(1))
+			})
+
+			It("reports the error left in the spool directory", func() {
+				Expect(diagnosis.SpoolErrors).To(Equal([]string{"[103] unable to find a valid repository: repo1: [FileMissingError] unable to load info file"}))
+				Expect(diagnosis.Causes).To(ConsistOf(ContainSubstring("unable to find a valid repository")))
+			})
+
+			Context("the spool directory is not writable", func() {
+				BeforeEach(func() {
+					runner.On("test -w /var/spool/backrest", "", "", h.ExitError{Status: 1})
+				})
+
+				It("reports it", func() {
+					Expect(diagnosis.Causes).To(ContainElement(ContainSubstring("spool path /var/spool/backrest is missing or not writable")))
+				})
+			})
+		})
+	})
+
+	Context("archiving is switched off", func() {
+		BeforeEach(func() {
+			runner.On("current_setting('archive_mode')", "off\n", "", nil)
+			runner.On("current_setting('archive_command')", "(disabled)\n", "", nil)
+		})
+
+		It("reports it", func() {
+			Expect(diagnosis.Causes).To(ConsistOf(
+				"archive_mode is off",
+				ContainSubstring("does not push to stanza pitr"),
+			))
+		})
+	})
+
+	Context("segments are archived elsewhere", func() {
+		BeforeEach(func() {
+			runner.On("from pg_stat_archiver", "15|00000001000000000000000F|1574763700|0||0|000000010000000000000010\n", "", nil)
+		})
+
+		It("reports it", func() {
+			Expect(diagnosis.Causes).To(ConsistOf(ContainSubstring("the repository ends at 00000001000000000000000C")))
+		})
+	})
+
+	Context("PostgreSQL 9.6", func() {
+		BeforeEach(func() {
+			runner.On("pgbackrest info", strings.Replace(readFixture("info-2.19.json"), `"version": "11"`, `"version": "9.6"`, -1), "", nil)
+
+			ctl = pgbackrest.NewController(runner, cluster.NewController(runner, "9.6", "main"))
+		})
+
+		It("uses the old names", func() {
+			Expect(runner.CommandsContaining("pg_xlogfile_name(pg_current_xlog_location())")).To(HaveLen(1))
+			Expect(runner.CommandsContaining("/var/lib/postgresql/9.6/main/pg_xlog/archive_status")).To(HaveLen(1))
+		})
+	})
+})
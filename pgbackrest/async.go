@@ -0,0 +1,248 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AsyncArchiving configures how archive-push and archive-get queue WAL in the spool directory.
+// Zero values keep the defaults of pgbackrest.
+type AsyncArchiving struct {
+	// SpoolPath is where WAL is queued; empty uses /var/spool/pgbackrest
+	SpoolPath string
+
+	// PushQueueMax is the size limit of WAL waiting to be pushed, e.g. "1GiB". Once it is exceeded,
+	// pgbackrest drops WAL and reports success to PostgreSQL, which breaks point-in-time recovery
+	// but keeps the primary from running out of space.
+	PushQueueMax string
+
+	// GetQueueMax is the size of WAL prefetched during recovery, e.g. "128MiB"
+	GetQueueMax string
+
+	// ProcessMax is the number of processes pushing and fetching WAL; 0 keeps the configured value
+	ProcessMax int
+}
+
+// SpoolQueue tells about the WAL queued for asynchronous archiving
+type SpoolQueue struct {
+	Path string
+
+	// Ready lists the segments PostgreSQL is waiting to archive
+	Ready []string
+
+	// Pushed lists the segments pgbackrest pushed, but PostgreSQL did not acknowledge yet
+	Pushed []string
+
+	// Failed lists the segments pgbackrest could not push
+	Failed []string
+
+	// Prefetched lists the segments archive-get fetched ahead of recovery
+	Prefetched []string
+}
+
+// EnableAsyncArchiving makes pgbackrest push and get WAL of the given stanza asynchronously.
+// It creates the spool directory and updates the deployed configuration.
+func (ctl Controller) EnableAsyncArchiving(stanza string, async AsyncArchiving) *Error {
+	conf, err := ctl.DeployedConf()
+
+	if err != nil {
+		return err
+	}
+
+	spoolPath := async.SpoolPath
+
+	if "" == spoolPath {
+		spoolPath = defaultSpoolPath
+	}
+
+	stdout, stderr, runErr := ctl.runner.Run("sudo install --directory --owner=postgres --group=postgres --mode=750 %s", shellQuote(spoolPath))
+
+	if runErr != nil {
+		return newError(stdout, stderr, runErr)
+	}
+
+	clearAsyncOptions(&conf, stanza)
+	conf.Set(stanza, "archive-async", "y")
+
+	if "" != async.SpoolPath {
+		conf.Set(stanza, "spool-path", async.SpoolPath)
+	}
+
+	if "" != async.PushQueueMax {
+		conf.Set(CommandSection(stanza, "archive-push"), "archive-push-queue-max", async.PushQueueMax)
+	}
+
+	if "" != async.GetQueueMax {
+		conf.Set(CommandSection(stanza, "archive-get"), "archive-get-queue-max", async.GetQueueMax)
+	}
+
+	if async.ProcessMax > 0 {
+		conf.Set(CommandSection(stanza, "archive-push"), "process-max", fmt.Sprintf("%d", async.ProcessMax))
+		conf.Set(CommandSection(stanza, "archive-get"), "process-max", fmt.Sprintf("%d", async.ProcessMax))
+	}
+
+	return ctl.PushConf(conf)
+}
+
+// DisableAsyncArchiving makes pgbackrest push and get WAL of the given stanza synchronously again.
+// WAL still queued in the spool directory is pushed first, so that none is left behind.
+func (ctl Controller) DisableAsyncArchiving(stanza string, timeout time.Duration) *Error {
+	conf, err := ctl.DeployedConf()
+
+	if err != nil {
+		return err
+	}
+
+	if "y" != confValue(conf, stanza, "archive-push", "archive-async") {
+		return nil
+	}
+
+	err = ctl.FlushArchive(stanza, timeout)
+
+	if err != nil {
+		return err
+	}
+
+	clearAsyncOptions(&conf, stanza)
+	conf.Set(stanza, "archive-async", "n")
+
+	return ctl.PushConf(conf)
+}
+
+// SpoolQueue reports the WAL queued for the given stanza
+func (ctl Controller) SpoolQueue(stanza string) (SpoolQueue, *Error) {
+	queue := SpoolQueue{}
+
+	conf, err := ctl.DeployedConf()
+
+	if err != nil {
+		return queue, err
+	}
+
+	queue.Path = confValue(conf, stanza, "archive-push", "spool-path")
+
+	if "" == queue.Path {
+		queue.Path = defaultSpoolPath
+	}
+
+	ready, pitrErr := ctl.cluster.ReadyWAL()
+
+	if pitrErr != nil {
+		return queue, fromPitrError(pitrErr)
+	}
+
+	queue.Ready = ready
+
+	outgoing, err := ctl.listFiles(fmt.Sprintf("%s/archive/%s/out", queue.Path, stanza))
+
+	if err != nil {
+		return queue, err
+	}
+
+	for _, file := range outgoing {
+		switch {
+		case strings.HasSuffix(file, ".ok"):
+			queue.Pushed = append(queue.Pushed, strings.TrimSuffix(file, ".ok"))
+		case strings.HasSuffix(file, ".error"):
+			queue.Failed = append(queue.Failed, strings.TrimSuffix(file, ".error"))
+		}
+	}
+
+	queue.Prefetched, err = ctl.listFiles(fmt.Sprintf("%s/archive/%s/in", queue.Path, stanza))
+
+	return queue, err
+}
+
+// Pending lists the segments that are ready, but were not pushed yet
+func (queue SpoolQueue) Pending() []string {
+	pushed := make(map[string]bool)
+
+	for _, segment := range queue.Pushed {
+		pushed[segment] = true
+	}
+
+	pending := make([]string, 0)
+
+	for _, segment := range queue.Ready {
+		if !pushed[segment] {
+			pending = append(pending, segment)
+		}
+	}
+
+	return pending
+}
+
+// FlushArchive completes the current WAL segment and waits until PostgreSQL has archived all segments,
+// including those queued for asynchronous archiving
+func (ctl Controller) FlushArchive(stanza string, timeout time.Duration) *Error {
+	segment, pitrErr := ctl.cluster.SwitchWAL()
+
+	if pitrErr != nil {
+		return fromPitrError(pitrErr)
+	}
+
+	err := ctl.waitFor(timeout, func() (bool, *Error) {
+		ready, pitrErr := ctl.cluster.ReadyWAL()
+
+		if pitrErr != nil {
+			return false, fromPitrError(pitrErr)
+		}
+
+		return 0 == len(ready), nil
+	}, fmt.Sprintf("WAL of stanza %s up to %s was not archived within %s", stanza, segment, timeout))
+
+	if err != nil {
+		err.Code = ErrorCodeArchiveTimeout
+	}
+
+	return err
+}
+
+// StopFlushed stops the cluster once all of its WAL is archived. If archiving does not complete
+// within the timeout, the cluster keeps running.
+func (ctl Controller) StopFlushed(stanza string, timeout time.Duration) *Error {
+	err := ctl.FlushArchive(stanza, timeout)
+
+	if err != nil {
+		return err
+	}
+
+	return fromPitrError(ctl.cluster.Stop())
+}
+
+// listFiles lists the files in a directory; it is empty if the directory does not exist (yet)
+func (ctl Controller) listFiles(directory string) ([]string, *Error) {
+	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres find %s -maxdepth 1 -type f -printf '%%f\\n'", directory)
+
+	if runErr != nil {
+		if strings.Contains(stderr, "No such file or directory") {
+			return nil, nil
+		}
+
+		return nil, newError(stdout, stderr, runErr)
+	}
+
+	files := make([]string, 0)
+
+	for _, line := range strings.Split(stdout, "\n") {
+		if "" != strings.TrimSpace(line) {
+			files = append(files, strings.TrimSpace(line))
+		}
+	}
+
+	return files, nil
+}
+
+// clearAsyncOptions removes the options for asynchronous archiving of the stanza
+func clearAsyncOptions(conf *Conf, stanza string) {
+	for _, section := range []string{stanza, CommandSection(stanza, "archive-push"), CommandSection(stanza, "archive-get")} {
+		for _, key := range []string{"archive-async", "spool-path", "archive-push-queue-max", "archive-get-queue-max", "process-max"} {
+			if section == stanza && "process-max" == key {
+				continue
+			}
+
+			conf.Unset(section, key)
+		}
+	}
+}
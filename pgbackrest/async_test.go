@@ -0,0 +1,150 @@
+package pgbackrest_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+const asyncConf = deployedConf + `
+[pitr:archive-push]
+process-max=2
+`
+
+var _ = Describe("Asynchronous archiving", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "")
+		runner.On("cat /etc/pgbackrest.conf", deployedConf, "", nil)
+		runner.On("select pg_walfile_name(pg_switch_wal())", "00000001000000000000000C\n", "", nil)
+		ctl.PollInterval = 0
+	})
+
+	Context("enabling", func() {
+		var pushed string
+
+		JustBeforeEach(func() {
+			err = ctl.EnableAsyncArchiving("pitr", pgbackrest.AsyncArchiving{
+				SpoolPath:    "/var/spool/pitr",
+				PushQueueMax: "1GiB",
+				GetQueueMax:  "128MiB",
+				ProcessMax:   4,
+			})
+
+			if commands := runner.InputsOf("tee /etc/pgbackrest.conf"); 1 == len(commands) {
+				pushed = commands[0]
+			}
+		})
+
+		It("creates the spool directory", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Commands).To(ContainElement("sudo install --directory --owner=postgres --group=postgres --mode=750 '/var/spool/pitr'"))
+		})
+
+		It("configures the stanza", func() {
+			Expect(pushed).To(And(
+				ContainSubstring("[pitr]\npg1-path=/var/lib/postgresql/11/main\nrecovery-option=recovery_target_action=promote\narchive-async=y\nspool-path=/var/spool/pitr\n"),
+				ContainSubstring("[pitr:archive-push]\narchive-push-queue-max=1GiB\nprocess-max=4\n"),
+				ContainSubstring("[pitr:archive-get]\narchive-get-queue-max=128MiB\nprocess-max=4\n"),
+			))
+		})
+	})
+
+	Context("disabling", func() {
+		BeforeEach(func() {
+			runner.On("cat /etc/pgbackrest.conf", asyncConf+"\n[pitr]\narchive-async=y\n", "", nil)
+			runner.Once("archive_status -name '*.ready'", "00000001000000000000000C.ready\n", "", nil)
+		})
+
+		JustBeforeEach(func() {
+			err = ctl.DisableAsyncArchiving("pitr", time.Minute)
+		})
+
+		It("flushes the queue first", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_switch_wal")).To(HaveLen(1))
+			Expect(runner.CommandsContaining("archive_status")).To(HaveLen(2))
+		})
+
+		It("archives synchronously again", func() {
+			pushed := runner.InputsOf("tee /etc/pgbackrest.conf")
+			Expect(pushed).To(HaveLen(1))
+			Expect(pushed[0]).To(ContainSubstring("archive-async=n\n"))
+			Expect(pushed[0]).NotTo(ContainSubstring("archive-async=y"))
+			Expect(pushed[0]).NotTo(ContainSubstring("process-max=2"))
+		})
+
+		Context("archiving is not asynchronous", func() {
+			BeforeEach(func() {
+				runner.On("cat /etc/pgbackrest.conf", deployedConf, "", nil)
+			})
+
+			It("does nothing", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("tee")).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("the spool queue", func() {
+		var queue pgbackrest.SpoolQueue
+
+		BeforeEach(func() {
+			runner.On("cat /etc/pgbackrest.conf", deployedConf+"\n[pitr]\narchive-async=y\nspool-path=/var/spool/pitr\n", "", nil)
+			runner.On("archive_status -name '*.ready'", "00000001000000000000000B.ready\n00000001000000000000000C.ready\n00000001000000000000000D.ready\n", "", nil)
+			runner.On("find /var/spool/pitr/archive/pitr/out", "00000001000000000000000B.ok\n00000001000000000000000C.error\n", "", nil)
+			runner.On("find /var/spool/pitr/archive/pitr/in", "", "find: '/var/spool/pitr/archive/pitr/in': No such file or directory", h.ExitError{Status: 1})
+		})
+
+		JustBeforeEach(func() {
+			queue, err = ctl.SpoolQueue("pitr")
+		})
+
+		It("has the state of each segment", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queue.Path).To(Equal("/var/spool/pitr"))
+			Expect(queue.Pushed).To(ConsistOf("00000001000000000000000B"))
+			Expect(queue.Failed).To(ConsistOf("00000001000000000000000C"))
+			Expect(queue.Prefetched).To(BeEmpty())
+		})
+
+		It("knows which segments still need to be pushed", func() {
+			Expect(queue.Pending()).To(ConsistOf("00000001000000000000000C", "00000001000000000000000D"))
+		})
+	})
+
+	Context("stopping the cluster after flushing", func() {
+		BeforeEach(func() {
+			runner.On("archive_status -name '*.ready'", "00000001000000000000000C.ready\n", "", nil)
+		})
+
+		JustBeforeEach(func() {
+			err = ctl.StopFlushed("pitr", 0)
+		})
+
+		Context("WAL is not archived in time", func() {
+			It("keeps the cluster running", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeArchiveTimeout))
+				Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+			})
+		})
+
+		Context("all WAL is archived", func() {
+			BeforeEach(func() {
+				runner.On("archive_status -name '*.ready'", "", "", nil)
+			})
+
+			It("stops the cluster", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("pg_ctlcluster 11 main stop")).To(HaveLen(1))
+			})
+		})
+	})
+})
@@ -0,0 +1,235 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backup types supported by pgbackrest
+const (
+	BackupTypeFull         = "full"
+	BackupTypeDifferential = "diff"
+	BackupTypeIncremental  = "incr"
+)
+
+// BackupOptions control how a backup is made. The zero value makes an incremental backup
+// with the settings from pgbackrest.conf.
+type BackupOptions struct {
+	// Type is one of BackupTypeFull, BackupTypeDifferential or BackupTypeIncremental (default)
+	Type string
+
+	// StartFast forces a checkpoint so that the backup starts immediately
+	StartFast bool
+
+	// Annotations are stored with the backup as key/value pairs
+	Annotations map[string]string
+
+	// ProcessMax is the number of processes used for compression and transfer; 0 keeps the configured value
+	ProcessMax int
+
+	// CompressType is one of none, gz, lz4, zst or bz2; empty keeps the configured value
+	CompressType string
+
+	// CompressLevel is the level of compression; 0 keeps the configured value (or the default of the type)
+	CompressLevel int
+
+	// BackupStandby copies the files from the standby instead of the primary; see Controller.WithStandby
+	BackupStandby bool
+
+	// Repo is the key of the repository to back up into; 0 uses the first repository
+	Repo int
+
+	// LockTimeout is how long to wait for another backup or expire of the stanza to finish; 0 fails immediately
+	LockTimeout time.Duration
+}
+
+// Backup creates a new backup for the given stanza and returns its label
+func (ctl Controller) Backup(stanza string, options BackupOptions) (string, *Error) {
+	args, err := options.args()
+
+	if err != nil {
+		return "", err
+	}
+
+	if options.BackupStandby {
+		err = ctl.ValidateStandby()
+
+		if err != nil {
+			return "", err
+		}
+
+		args += ctl.standbyArgs()
+	}
+
+	previous, err := ctl.latestLabel(stanza, options.Repo)
+
+	if err != nil {
+		return "", err
+	}
+
+	stdout, stderr, err := ctl.backupWhenUnlocked(stanza, args, options.LockTimeout)
+
+	if err != nil {
+		return "", err
+	}
+
+	label, err := ctl.latestLabel(stanza, options.Repo)
+
+	if err != nil {
+		return "", err
+	}
+
+	if "" == label || label == previous {
+		return "", &Error{
+			Message: fmt.Sprintf("Could not find the new backup of stanza %s", stanza),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return label, nil
+}
+
+// latestLabel returns the label of the latest backup of the given stanza in the given repository, or an empty
+// string if there is none. Repository 0 is the first one, which pgbackrest backs up into unless told otherwise.
+// It is not passed to pgbackrest info, because versions before 2.33 have no --repo option.
+func (ctl Controller) latestLabel(stanza string, repo int) (string, *Error) {
+	infos, err := ctl.InfoForRepo(stanza, repo)
+
+	if err != nil {
+		return "", err
+	}
+
+	if 0 == repo {
+		repo = 1
+	}
+
+	for _, info := range infos {
+		if info.Name != stanza {
+			continue
+		}
+
+		if backups := info.BackupsInRepo(repo); 0 < len(backups) {
+			return backups[len(backups)-1].Label, nil
+		}
+	}
+
+	return "", nil
+}
+
+// FullBackup creates a new full backup for the given stanza
+func (ctl Controller) FullBackup(stanza string) *Error {
+	_, err := ctl.Backup(stanza, BackupOptions{Type: BackupTypeFull})
+	return err
+}
+
+func (options BackupOptions) args() (string, *Error) {
+	var args strings.Builder
+
+	switch options.Type {
+	case "":
+		args.WriteString(" --type=" + BackupTypeIncremental)
+	case BackupTypeFull, BackupTypeDifferential, BackupTypeIncremental:
+		args.WriteString(" --type=" + options.Type)
+	default:
+		return "", &Error{
+			Message: fmt.Sprintf("Unknown backup type '%s'", options.Type),
+		}
+	}
+
+	if options.Repo > 0 {
+		fmt.Fprintf(&args, " --repo=%d", options.Repo)
+	}
+
+	if options.StartFast {
+		args.WriteString(" --start-fast")
+	}
+
+	if options.BackupStandby {
+		args.WriteString(" --backup-standby")
+	}
+
+	if options.ProcessMax > 0 {
+		fmt.Fprintf(&args, " --process-max=%d", options.ProcessMax)
+	}
+
+	if "" != options.CompressType {
+		args.WriteString(" --compress-type=" + shellQuote(options.CompressType))
+	}
+
+	if options.CompressLevel > 0 {
+		fmt.Fprintf(&args, " --compress-level=%d", options.CompressLevel)
+	}
+
+	args.WriteString(annotationArgs(options.Annotations))
+
+	return args.String(), nil
+}
+
+// Annotate adds or changes annotations of an existing backup. An empty value removes the annotation.
+func (ctl Controller) Annotate(stanza, set string, annotations map[string]string) *Error {
+	if 0 == len(annotations) {
+		return &Error{
+			Message: "At least one annotation is required",
+			Code:    ErrorCodeOptionRequired,
+		}
+	}
+
+	info, err := ctl.stanzaInfo(stanza)
+
+	if err != nil {
+		return err
+	}
+
+	if info.Backup(set) == nil {
+		return &Error{
+			Message: fmt.Sprintf("Stanza %s has no backup %s", stanza, set),
+			Code:    ErrorCodeBackupSetInvalid,
+		}
+	}
+
+	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s --set=%s%s annotate", stanza, shellQuote(set), annotationArgs(annotations))
+
+	if runErr != nil {
+		return newError(stdout, stderr, runErr)
+	}
+
+	return nil
+}
+
+// annotationArgs renders the annotations as pgbackrest arguments
+func annotationArgs(annotations map[string]string) string {
+	var args strings.Builder
+
+	for _, annotation := range sortedAnnotations(annotations) {
+		args.WriteString(" --annotation=" + shellQuote(annotation))
+	}
+
+	return args.String()
+}
+
+// sortedAnnotations renders the annotations as key=value, sorted by key
+func sortedAnnotations(annotations map[string]string) []string {
+	keys := make([]string, 0, len(annotations))
+
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+annotations[key])
+	}
+
+	return pairs
+}
+
+// shellQuote protects the given value from being interpreted by the remote shell
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
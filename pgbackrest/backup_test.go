@@ -0,0 +1,288 @@
+package pgbackrest_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Backup", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var options pgbackrest.BackupOptions
+	var label string
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "info-2.19.json")
+		runner.Once("pgbackrest info", withoutBackup(readFixture("info-2.19.json"), "20191126-101005F_20191126-102044I"), "", nil)
+		options = pgbackrest.BackupOptions{}
+	})
+
+	JustBeforeEach(func() {
+		label, err = ctl.Backup("pitr", options)
+	})
+
+	It("returns the label of the new backup", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(label).To(Equal("20191126-101005F_20191126-102044I"))
+	})
+
+	It("makes an incremental backup by default", func() {
+		Expect(runner.CommandsContaining("pgbackrest --stanza=pitr backup --type=incr")).To(HaveLen(1))
+	})
+
+	Context("only the compression type is set", func() {
+		BeforeEach(func() {
+			options.CompressType = "zst"
+		})
+
+		It("keeps the default level of that type", func() {
+			Expect(runner.CommandsContaining("backup")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr backup --type=incr --compress-type='zst'"))
+		})
+	})
+
+	Context("only the compression level is set", func() {
+		BeforeEach(func() {
+			options.CompressLevel = 9
+		})
+
+		It("keeps the configured type", func() {
+			Expect(runner.CommandsContaining("backup")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr backup --type=incr --compress-level=9"))
+		})
+	})
+
+	Context("all options are set", func() {
+		BeforeEach(func() {
+			standbyRunner := &h.FakeRunner{}
+			standbyRunner.On("select pg_is_in_recovery()", "t\n", "", nil)
+			standbyRunner.On("from pg_stat_wal_receiver", "streaming\n", "", nil)
+
+			ctl = ctl.WithStandby(pgbackrest.Standby{
+				Runner:  standbyRunner,
+				Cluster: cluster.NewController(standbyRunner, "11", "main"),
+				Host:    "standby",
+			})
+
+			options = pgbackrest.BackupOptions{
+				Type:          pgbackrest.BackupTypeFull,
+				StartFast:     true,
+				BackupStandby: true,
+				ProcessMax:    2,
+				CompressType:  "lz4",
+				CompressLevel: 1,
+				Annotations: map[string]string{
+					"ticket":  "OPS-42",
+					"comment": "Bob's pre-migration backup",
+				},
+			}
+		})
+
+		It("passes them to pgbackrest", func() {
+			Expect(runner.CommandsContaining("backup")).To(ConsistOf(
+				"sudo --user postgres pgbackrest --stanza=pitr backup" +
+					" --type=full" +
+					" --start-fast" +
+					" --backup-standby" +
+					" --process-max=2" +
+					" --compress-type='lz4' --compress-level=1" +
+					` --annotation='comment=Bob'\''s pre-migration backup'` +
+					" --annotation='ticket=OPS-42'" +
+					" --pg2-host='standby' --pg2-path=/var/lib/postgresql/11/main",
+			))
+		})
+	})
+
+	Context("backing up from the standby", func() {
+		var standbyRunner *h.FakeRunner
+
+		BeforeEach(func() {
+			runner.On("pgbackrest version", "pgBackRest 2.36\n", "", nil)
+
+			standbyRunner = &h.FakeRunner{}
+			standbyRunner.On("select pg_is_in_recovery()", "t\n", "", nil)
+			standbyRunner.On("from pg_stat_wal_receiver", "streaming\n", "", nil)
+			standbyRunner.On("pgbackrest version", "pgBackRest 2.36\n", "", nil)
+
+			ctl = ctl.WithStandby(pgbackrest.Standby{
+				Runner:  standbyRunner,
+				Cluster: cluster.NewController(standbyRunner, "11", "main"),
+				Host:    "192.168.71.30",
+			})
+
+			options.BackupStandby = true
+		})
+
+		It("tells pgbackrest where the standby is", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("backup")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr backup --type=incr --backup-standby --pg2-host='192.168.71.30' --pg2-path=/var/lib/postgresql/11/main"))
+		})
+
+		Context("the standby is not replicating", func() {
+			BeforeEach(func() {
+				standbyRunner.On("from pg_stat_wal_receiver", "", "", nil)
+			})
+
+			It("does not start the backup", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Message).To(ContainSubstring("not replicating"))
+				Expect(runner.CommandsContaining("backup")).To(BeEmpty())
+			})
+		})
+
+		Context("the standby was promoted", func() {
+			BeforeEach(func() {
+				standbyRunner.On("select pg_is_in_recovery()", "f\n", "", nil)
+			})
+
+			It("does not start the backup", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(runner.CommandsContaining("backup")).To(BeEmpty())
+			})
+		})
+
+		Context("the standby runs another pgbackrest version", func() {
+			BeforeEach(func() {
+				standbyRunner.On("pgbackrest version", "pgBackRest 2.33\n", "", nil)
+			})
+
+			It("does not start the backup", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Message).To(ContainSubstring("pgBackRest 2.33"))
+				Expect(runner.CommandsContaining("backup")).To(BeEmpty())
+			})
+		})
+
+		Context("no standby is known", func() {
+			BeforeEach(func() {
+				ctl = pgbackrest.NewController(runner, cluster.NewController(runner, "11", "main"))
+			})
+
+			It("is rejected", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeOptionInvalid))
+			})
+		})
+	})
+
+	Context("a differential backup", func() {
+		BeforeEach(func() {
+			options.Type = pgbackrest.BackupTypeDifferential
+		})
+
+		It("passes the type", func() {
+			Expect(runner.CommandsContaining("backup --type=diff")).To(HaveLen(1))
+		})
+	})
+
+	Context("an unknown backup type", func() {
+		BeforeEach(func() {
+			options.Type = "weekly"
+		})
+
+		It("is rejected", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.Commands).To(BeEmpty())
+		})
+	})
+
+	Context("another backup holds the lock", func() {
+		BeforeEach(func() {
+			ctl.PollInterval = 0
+			runner.Once("backup --type", "", "ERROR: [050]: unable to acquire lock on file '/tmp/pgbackrest/pitr-backup.lock'", h.ExitError{Status: 50})
+		})
+
+		It("fails immediately by default", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.IsLockContention()).To(BeTrue())
+			Expect(runner.CommandsContaining("backup --type")).To(HaveLen(1))
+		})
+
+		Context("waiting for the lock", func() {
+			BeforeEach(func() {
+				options.LockTimeout = time.Minute
+			})
+
+			It("retries once the lock is released", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("backup --type")).To(HaveLen(2))
+				Expect(label).To(Equal("20191126-101005F_20191126-102044I"))
+			})
+		})
+
+		Context("the lock is not released in time", func() {
+			BeforeEach(func() {
+				options.LockTimeout = time.Nanosecond
+				runner.On("backup --type", "", "ERROR: [050]: unable to acquire lock", h.ExitError{Status: 50})
+			})
+
+			It("gives up", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.IsLockContention()).To(BeTrue())
+				Expect(err.Message).To(ContainSubstring("still holds the lock"))
+			})
+		})
+	})
+
+	Context("the backup fails", func() {
+		BeforeEach(func() {
+			runner.On("backup --type", "", "ERROR: [050]: unable to acquire lock", h.ExitError{Status: 50})
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Stderr).To(ContainSubstring("unable to acquire lock"))
+			Expect(label).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Annotate", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var annotations map[string]string
+	var set string
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "info-2.41-annotations.json")
+		set = "20191126-101005F"
+		annotations = map[string]string{"ticket": "OPS-43", "release": ""}
+	})
+
+	JustBeforeEach(func() {
+		err = ctl.Annotate("pitr", set, annotations)
+	})
+
+	It("updates the annotations of the backup", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("annotate")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --set='20191126-101005F' --annotation='release=' --annotation='ticket=OPS-43' annotate"))
+	})
+
+	Context("the backup does not exist", func() {
+		BeforeEach(func() {
+			set = "20191126-000000F"
+		})
+
+		It("is rejected", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeBackupSetInvalid))
+			Expect(runner.CommandsContaining("annotate")).To(BeEmpty())
+		})
+	})
+
+	Context("no annotations are given", func() {
+		BeforeEach(func() {
+			annotations = nil
+		})
+
+		It("is rejected", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.Commands).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,260 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultRepoPath is where pgbackrest keeps a repository unless repoN-path is set
+const defaultRepoPath = "/var/lib/pgbackrest"
+
+// repoOptionPattern matches repository options like repo2-cipher-pass
+var repoOptionPattern = regexp.MustCompile(`^repo(\d+)-(.+)$`)
+
+// CipherRotation describes the repository taking over the backups of a stanza with a new cipher pass
+type CipherRotation struct {
+	// CipherPass is the new key; it must differ from the key of the repository it replaces
+	CipherPass string
+
+	// From is the key of the repository whose settings are copied; 0 copies repository 1
+	From int
+
+	// Path is the location of the new repository; empty appends the new key to the path of the copied repository
+	Path string
+}
+
+// RotatedRepo is the repository created by RotateCipherPass and its first backup
+type RotatedRepo struct {
+	Repo  int
+	Path  string
+	Label string
+}
+
+// ValidateCipher makes sure that the configured cipher pass decrypts all repositories of the given stanza,
+// and that the stanza passes `pgbackrest check`
+func (ctl Controller) ValidateCipher(stanza string) *Error {
+	infos, err := ctl.Info(stanza)
+
+	if err != nil {
+		if ErrorCodeCrypto == err.Code {
+			err.Message = fmt.Sprintf("The cipher pass does not decrypt the repository of stanza %s: %s", stanza, err.Message)
+		}
+
+		return err
+	}
+
+	for _, info := range infos {
+		if info.Name != stanza {
+			continue
+		}
+
+		if 0 == len(info.Repos) && isCryptoMessage(info.Status.Message) {
+			return &Error{
+				Message: fmt.Sprintf("The cipher pass does not decrypt the repository of stanza %s: %s", stanza, info.Status.Message),
+				Code:    ErrorCodeCrypto,
+			}
+		}
+
+		for _, repo := range info.Repos {
+			if !repo.OK() && isCryptoMessage(repo.Status.Message) {
+				return &Error{
+					Message: fmt.Sprintf("The cipher pass does not decrypt repository %d of stanza %s: %s", repo.Key, stanza, repo.Status.Message),
+					Code:    ErrorCodeCrypto,
+				}
+			}
+		}
+	}
+
+	return ctl.Check(stanza)
+}
+
+// RotateCipherPass adds a repository encrypted with the new cipher pass, creates the stanza in it and makes
+// a full backup into it. The settings of the new repository are copied from an existing one. If the new
+// repository cannot be set up or the backup into it fails, the previous configuration is restored; whatever
+// was written to the new repository stays there.
+//
+// The old repository keeps its backups and continues to receive WAL. Once its backups are no longer needed,
+// set cipher_pass, repo and repo_path in config.yml to the new key and repository, and push ExpectedConf,
+// which no longer has the options of the old repository.
+func (ctl Controller) RotateCipherPass(stanza string, rotation CipherRotation) (RotatedRepo, *Error) {
+	rotated := RotatedRepo{}
+
+	if "" == rotation.CipherPass {
+		return rotated, &Error{
+			Message: "The new cipher pass must not be empty",
+			Code:    ErrorCodeOptionRequired,
+		}
+	}
+
+	from := rotation.From
+
+	if 0 == from {
+		from = 1
+	}
+
+	conf, err := ctl.DeployedConf()
+
+	if err != nil {
+		return rotated, err
+	}
+
+	previous, parseErr := ParseConf(conf.String())
+
+	if parseErr != nil {
+		return rotated, &Error{
+			Message: parseErr.Error(),
+			Code:    ErrorCodeConfig,
+		}
+	}
+
+	if !hasRepo(conf, from) {
+		return rotated, &Error{
+			Message: fmt.Sprintf("Repository %d is not configured in %s", from, ConfPath),
+			Code:    ErrorCodeRepoInvalid,
+		}
+	}
+
+	if currentPass, _ := conf.Get(GlobalSection, repoOption(from, "cipher-pass")); currentPass == rotation.CipherPass {
+		return rotated, &Error{
+			Message: fmt.Sprintf("Repository %d already uses the new cipher pass", from),
+			Code:    ErrorCodeOptionInvalidValue,
+		}
+	}
+
+	rotated.Repo = nextRepoKey(conf)
+	rotated.Path = rotation.Path
+
+	if "" == rotated.Path {
+		rotated.Path = repoPath(conf, from) + fmt.Sprintf("-%d", rotated.Repo)
+	}
+
+	copyRepo(&conf, from, rotated.Repo)
+	conf.Set(GlobalSection, repoOption(rotated.Repo, "path"), rotated.Path)
+	conf.Set(GlobalSection, repoOption(rotated.Repo, "cipher-type"), "aes-256-cbc")
+	conf.Set(GlobalSection, repoOption(rotated.Repo, "cipher-pass"), rotation.CipherPass)
+
+	err = ctl.PushConf(conf)
+
+	if err != nil {
+		return rotated, err
+	}
+
+	err = ctl.createRepo(stanza, conf, rotated)
+
+	if err != nil {
+		return rotated, ctl.restoreConf(previous, err)
+	}
+
+	rotated.Label, err = ctl.Backup(stanza, BackupOptions{Type: BackupTypeFull, Repo: rotated.Repo})
+
+	if err != nil {
+		return rotated, ctl.restoreConf(previous, err)
+	}
+
+	return rotated, nil
+}
+
+// restoreConf pushes the previous configuration after a failed rotation and returns the original error
+func (ctl Controller) restoreConf(previous Conf, cause *Error) *Error {
+	err := ctl.PushConf(previous)
+
+	if err != nil {
+		cause.Message = fmt.Sprintf("%s; restoring the previous configuration failed, too: %s", cause.Message, err.Message)
+	}
+
+	return cause
+}
+
+// createRepo creates the stanza in the new repository and makes sure it can be decrypted
+func (ctl Controller) createRepo(stanza string, conf Conf, rotated RotatedRepo) *Error {
+	if repoType, _ := conf.Get(GlobalSection, repoOption(rotated.Repo, "type")); "" == repoType || "posix" == repoType {
+		stdout, stderr, runErr := ctl.runner.Run("sudo install --directory --owner=postgres --group=postgres --mode=750 %s", shellQuote(rotated.Path))
+
+		if runErr != nil {
+			return newError(stdout, stderr, runErr)
+		}
+	}
+
+	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s --repo=%d stanza-create", stanza, rotated.Repo)
+
+	if runErr != nil {
+		return newError(stdout, stderr, runErr)
+	}
+
+	return ctl.ValidateCipher(stanza)
+}
+
+// hasRepo tells whether any option of the repository with the given key is configured
+func hasRepo(conf Conf, key int) bool {
+	for _, section := range conf.Sections {
+		for _, option := range section.Options {
+			if match := repoOptionPattern.FindStringSubmatch(option.Key); match != nil && match[1] == strconv.Itoa(key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// nextRepoKey returns the key following the highest repository key in use
+func nextRepoKey(conf Conf) int {
+	highest := 0
+
+	for _, section := range conf.Sections {
+		for _, option := range section.Options {
+			if match := repoOptionPattern.FindStringSubmatch(option.Key); match != nil && atoi(match[1]) > highest {
+				highest = atoi(match[1])
+			}
+		}
+	}
+
+	return highest + 1
+}
+
+// copyRepo copies the options of one repository to another one, except for the cipher settings
+func copyRepo(conf *Conf, from, to int) {
+	type sectionOption struct {
+		section string
+		option  ConfOption
+	}
+
+	copied := make([]sectionOption, 0)
+
+	for _, section := range conf.Sections {
+		for _, option := range section.Options {
+			match := repoOptionPattern.FindStringSubmatch(option.Key)
+
+			if match == nil || match[1] != strconv.Itoa(from) || strings.HasPrefix(match[2], "cipher-") {
+				continue
+			}
+
+			copied = append(copied, sectionOption{section.Name, ConfOption{Key: repoOption(to, match[2]), Value: option.Value}})
+		}
+	}
+
+	for _, copy := range copied {
+		conf.Add(copy.section, copy.option.Key, copy.option.Value)
+	}
+}
+
+// repoPath returns the configured path of the repository with the given key
+func repoPath(conf Conf, key int) string {
+	if path, found := conf.Get(GlobalSection, repoOption(key, "path")); found {
+		return path
+	}
+
+	return defaultRepoPath
+}
+
+func repoOption(key int, name string) string {
+	return fmt.Sprintf("repo%d-%s", key, name)
+}
+
+// isCryptoMessage tells whether pgbackrest reported a decryption problem, either by the name of the error,
+// like "[CryptoError] unable to flush", or by its code, like "ERROR: [095]: unable to flush"
+func isCryptoMessage(message string) bool {
+	return strings.Contains(message, "["+errorDescriptions[ErrorCodeCrypto].name+"]") || ErrorCodeCrypto == parseErrorCode(message)
+}
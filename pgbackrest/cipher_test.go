@@ -0,0 +1,208 @@
+package pgbackrest_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	cfg "github.com/suhlig/postgres-pitr/config"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Cipher", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var info string
+
+	BeforeEach(func() {
+		info = readFixture("info-2.36-multi-repo.json")
+
+		runner, ctl = fakeController("13", "info-2.36-multi-repo.json")
+		runner.On("cat /etc/pgbackrest.conf", deployedConf, "", nil)
+	})
+
+	Context("validating the cipher pass", func() {
+		var err *pgbackrest.Error
+
+		JustBeforeEach(func() {
+			err = ctl.ValidateCipher("pitr")
+		})
+
+		It("checks the stanza", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Commands).To(ContainElement("sudo --user postgres pgbackrest --stanza=pitr check"))
+		})
+
+		Context("a repository cannot be decrypted", func() {
+			BeforeEach(func() {
+				runner.On("pgbackrest info", strings.Replace(info, `"message": "other"`, `"message": "[CryptoError] unable to flush EVP_DecryptFinal_ex"`, 1), "", nil)
+			})
+
+			It("names the repository", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeCrypto))
+				Expect(err.Message).To(ContainSubstring("repository 3"))
+				Expect(runner.CommandsContaining("check")).To(BeEmpty())
+			})
+		})
+
+		Context("a repository fails for another reason that mentions the cipher", func() {
+			BeforeEach(func() {
+				runner.On("pgbackrest info", strings.Replace(info, `"message": "other"`, `"message": "[FileMissingError] unable to open missing file '/var/lib/pgbackrest/backup/pitr/backup.info' (check repo1-cipher-pass)"`, 1), "", nil)
+			})
+
+			It("is not mistaken for a wrong cipher pass", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("pgbackrest info fails to decrypt", func() {
+			BeforeEach(func() {
+				runner.On("pgbackrest info", "", "ERROR: [095]: unable to flush EVP_DecryptFinal_ex", h.ExitError{Status: 95})
+			})
+
+			It("fails", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeCrypto))
+				Expect(err.Message).To(ContainSubstring("does not decrypt"))
+			})
+		})
+	})
+
+	Context("rotating the cipher pass", func() {
+		var rotation pgbackrest.CipherRotation
+		var rotated pgbackrest.RotatedRepo
+		var err *pgbackrest.Error
+
+		BeforeEach(func() {
+			rotation = pgbackrest.CipherRotation{CipherPass: "new secret"}
+
+			// validating the new repository and looking for the latest backup in it precede the backup
+			runner.Once("pgbackrest info", withoutBackup(info, "20211001-110000F"), "", nil)
+			runner.Once("pgbackrest info", withoutBackup(info, "20211001-110000F"), "", nil)
+		})
+
+		JustBeforeEach(func() {
+			rotated, err = ctl.RotateCipherPass("pitr", rotation)
+		})
+
+		It("adds an encrypted repository next to the existing one", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotated.Repo).To(Equal(2))
+			Expect(rotated.Path).To(Equal("/var/lib/pgbackrest-2"))
+
+			pushed := runner.InputsOf("tee /etc/pgbackrest.conf")
+			Expect(pushed).To(HaveLen(1))
+			Expect(pushed[0]).To(And(
+				ContainSubstring("repo1-cipher-type=none\n"),
+				ContainSubstring("repo2-path=/var/lib/pgbackrest-2\n"),
+				ContainSubstring("repo2-retention-full=2\n"),
+				ContainSubstring("repo2-cipher-type=aes-256-cbc\n"),
+				ContainSubstring("repo2-cipher-pass=new secret\n"),
+			))
+		})
+
+		It("creates the stanza in the new repository and makes a full backup into it", func() {
+			Expect(runner.Commands).To(ContainElement("sudo install --directory --owner=postgres --group=postgres --mode=750 '/var/lib/pgbackrest-2'"))
+			Expect(runner.Commands).To(ContainElement("sudo --user postgres pgbackrest --stanza=pitr --repo=2 stanza-create"))
+			Expect(runner.CommandsContaining("backup")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr backup --type=full --repo=2"))
+			Expect(rotated.Label).To(Equal("20211001-110000F"))
+		})
+
+		Context("the stanza cannot be created", func() {
+			BeforeEach(func() {
+				runner.On("stanza-create", "", "ERROR: [055]: unable to load info file", h.ExitError{Status: 55})
+			})
+
+			It("restores the previous configuration", func() {
+				Expect(err).To(HaveOccurred())
+
+				pushed := runner.InputsOf("tee /etc/pgbackrest.conf")
+				Expect(pushed).To(HaveLen(2))
+				Expect(pushed[1]).NotTo(ContainSubstring("repo2-"))
+				Expect(runner.CommandsContaining("backup")).To(BeEmpty())
+			})
+		})
+
+		Context("the full backup fails", func() {
+			BeforeEach(func() {
+				runner.On("backup --type=full", "", "ERROR: [082]: WAL segment was not archived before the 60000ms timeout", h.ExitError{Status: 82})
+			})
+
+			It("restores the previous configuration", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeArchiveTimeout))
+
+				pushed := runner.InputsOf("tee /etc/pgbackrest.conf")
+				Expect(pushed).To(HaveLen(2))
+				Expect(pushed[1]).NotTo(ContainSubstring("repo2-"))
+			})
+		})
+
+		Context("the old repository is retired as documented", func() {
+			var config cfg.Config
+
+			BeforeEach(func() {
+				var loadErr error
+				config, loadErr = config.FromFile("../config.yml")
+				Expect(loadErr).NotTo(HaveOccurred())
+
+				config.PgBackRest.ArchiveType = "local"
+				config.PgBackRest.CipherPass = "new secret"
+				config.PgBackRest.Repo = 2
+				config.PgBackRest.RepoPath = "/var/lib/pgbackrest-2"
+			})
+
+			It("keeps the new repository when correcting the configuration", func() {
+				Expect(err).NotTo(HaveOccurred())
+				runner.On("cat /etc/pgbackrest.conf", runner.InputsOf("tee /etc/pgbackrest.conf")[0], "", nil)
+
+				expected, confErr := pgbackrest.ExpectedConf(config, false)
+				Expect(confErr).NotTo(HaveOccurred())
+
+				differences, diffErr := ctl.DiffConf(expected)
+				Expect(diffErr).NotTo(HaveOccurred())
+				Expect(differences).To(ConsistOf(
+					pgbackrest.ConfDifference{Section: "global", Key: "repo1-path", Actual: "/var/lib/pgbackrest"},
+					pgbackrest.ConfDifference{Section: "global", Key: "repo1-retention-full", Actual: "2"},
+					pgbackrest.ConfDifference{Section: "global", Key: "repo1-retention-diff", Actual: "2"},
+					pgbackrest.ConfDifference{Section: "global", Key: "repo1-cipher-type", Actual: "none"},
+				))
+
+				Expect(ctl.PushConf(expected)).NotTo(HaveOccurred())
+
+				pushed := runner.InputsOf("tee /etc/pgbackrest.conf")
+				Expect(pushed[len(pushed)-1]).To(And(
+					ContainSubstring("repo2-path=/var/lib/pgbackrest-2\n"),
+					ContainSubstring("repo2-cipher-type=aes-256-cbc\n"),
+					ContainSubstring("repo2-cipher-pass=new secret\n"),
+					Not(ContainSubstring("repo1-")),
+				))
+			})
+		})
+
+		Context("the repository to copy is not configured", func() {
+			BeforeEach(func() {
+				rotation.From = 3
+			})
+
+			It("does not touch the configuration", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeRepoInvalid))
+				Expect(runner.CommandsContaining("tee")).To(BeEmpty())
+			})
+		})
+
+		Context("no new cipher pass is given", func() {
+			BeforeEach(func() {
+				rotation.CipherPass = ""
+			})
+
+			It("is rejected", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(runner.Commands).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -0,0 +1,252 @@
+package pgbackrest
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConfPath is where pgbackrest looks for its configuration
+const ConfPath = "/etc/pgbackrest.conf"
+
+// GlobalSection holds the options applying to all stanzas
+const GlobalSection = "global"
+
+// Conf models pgbackrest.conf. Sections are named "global", after a stanza, or after a stanza (or global)
+// and a command, like "global:archive-push". Within a section, an option may occur more than once,
+// e.g. recovery-option.
+type Conf struct {
+	Sections []ConfSection
+}
+
+// ConfSection is a named section of pgbackrest.conf
+type ConfSection struct {
+	Name    string
+	Options []ConfOption
+}
+
+// ConfOption is a single key=value line of pgbackrest.conf
+type ConfOption struct {
+	Key   string
+	Value string
+}
+
+// ConfDifference describes an option whose value differs between the expected and the actual configuration.
+// Values of options occurring more than once are joined with newlines; missing options have an empty value.
+type ConfDifference struct {
+	Section  string
+	Key      string
+	Expected string
+	Actual   string
+}
+
+// CommandSection returns the name of the section for options of the given command,
+// e.g. CommandSection(GlobalSection, "archive-push") is "global:archive-push"
+func CommandSection(section, command string) string {
+	return section + ":" + command
+}
+
+// ParseConf parses the INI format of pgbackrest.conf. Comments and empty lines are ignored.
+func ParseConf(content string) (Conf, error) {
+	conf := Conf{}
+	var section *ConfSection
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = conf.section(strings.TrimSpace(line[1:len(line)-1]), true)
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+
+		if len(parts) != 2 {
+			return conf, fmt.Errorf("Error parsing line %d '%s'; expected key=value", lineNumber, line)
+		}
+
+		if section == nil {
+			return conf, fmt.Errorf("Error parsing line %d '%s'; option outside of a section", lineNumber, line)
+		}
+
+		section.Options = append(section.Options, ConfOption{
+			Key:   strings.TrimSpace(parts[0]),
+			Value: strings.TrimSpace(parts[1]),
+		})
+	}
+
+	return conf, scanner.Err()
+}
+
+// String renders the configuration in the INI format of pgbackrest.conf
+func (conf Conf) String() string {
+	var result strings.Builder
+
+	for i, section := range conf.Sections {
+		if i > 0 {
+			result.WriteString("\n")
+		}
+
+		fmt.Fprintf(&result, "[%s]\n", section.Name)
+
+		for _, option := range section.Options {
+			fmt.Fprintf(&result, "%s=%s\n", option.Key, option.Value)
+		}
+	}
+
+	return result.String()
+}
+
+// Get returns the value of the given option, and whether it is set. If the option occurs
+// more than once, the first value is returned.
+func (conf Conf) Get(section, key string) (string, bool) {
+	values := conf.Values(section, key)
+
+	if 0 == len(values) {
+		return "", false
+	}
+
+	return values[0], true
+}
+
+// Values returns all values of the given option
+func (conf Conf) Values(section, key string) []string {
+	values := make([]string, 0)
+	s := conf.section(section, false)
+
+	if s == nil {
+		return values
+	}
+
+	for _, option := range s.Options {
+		if option.Key == key {
+			values = append(values, option.Value)
+		}
+	}
+
+	return values
+}
+
+// Set replaces all occurrences of the given option with the given value, adding the section if necessary
+func (conf *Conf) Set(section, key, value string) {
+	conf.Unset(section, key)
+	conf.Add(section, key, value)
+}
+
+// Add appends the given option to the section, keeping existing occurrences
+func (conf *Conf) Add(section, key, value string) {
+	s := conf.section(section, true)
+	s.Options = append(s.Options, ConfOption{Key: key, Value: value})
+}
+
+// Unset removes all occurrences of the given option
+func (conf *Conf) Unset(section, key string) {
+	s := conf.section(section, false)
+
+	if s == nil {
+		return
+	}
+
+	options := s.Options[:0]
+
+	for _, option := range s.Options {
+		if option.Key != key {
+			options = append(options, option)
+		}
+	}
+
+	s.Options = options
+}
+
+// Diff lists the options that need to change to turn the actual configuration into the expected one
+func (conf Conf) Diff(actual Conf) []ConfDifference {
+	differences := make([]ConfDifference, 0)
+
+	for _, section := range unionOfSections(conf, actual) {
+		for _, key := range unionOfKeys(conf, actual, section) {
+			expectedValues := conf.Values(section, key)
+			actualValues := actual.Values(section, key)
+			sort.Strings(expectedValues)
+			sort.Strings(actualValues)
+
+			expected := strings.Join(expectedValues, "\n")
+			found := strings.Join(actualValues, "\n")
+
+			if expected != found {
+				differences = append(differences, ConfDifference{
+					Section:  section,
+					Key:      key,
+					Expected: expected,
+					Actual:   found,
+				})
+			}
+		}
+	}
+
+	return differences
+}
+
+func (conf *Conf) section(name string, create bool) *ConfSection {
+	for i := range conf.Sections {
+		if conf.Sections[i].Name == name {
+			return &conf.Sections[i]
+		}
+	}
+
+	if !create {
+		return nil
+	}
+
+	conf.Sections = append(conf.Sections, ConfSection{Name: name})
+
+	return &conf.Sections[len(conf.Sections)-1]
+}
+
+// unionOfSections returns the names of all sections in either configuration, in order of appearance
+func unionOfSections(confs ...Conf) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+
+	for _, conf := range confs {
+		for _, section := range conf.Sections {
+			if !seen[section.Name] {
+				seen[section.Name] = true
+				names = append(names, section.Name)
+			}
+		}
+	}
+
+	return names
+}
+
+// unionOfKeys returns the keys of all options of the given section in either configuration, in order of appearance
+func unionOfKeys(expected, actual Conf, section string) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+
+	for _, conf := range []Conf{expected, actual} {
+		s := conf.section(section, false)
+
+		if s == nil {
+			continue
+		}
+
+		for _, option := range s.Options {
+			if !seen[option.Key] {
+				seen[option.Key] = true
+				keys = append(keys, option.Key)
+			}
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,276 @@
+package pgbackrest_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	cfg "github.com/suhlig/postgres-pitr/config"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+const deployedConf = `# Ansible managed
+
+[pitr]
+pg1-path=/var/lib/postgresql/11/main
+
+# https://www.postgresql.org/docs/current/recovery-target-settings.html
+recovery-option=recovery_target_action=promote
+
+[global]
+repo1-path=/var/lib/pgbackrest
+repo1-retention-full=2
+repo1-retention-diff=2
+
+repo1-cipher-type=none
+
+start-fast=y
+stop-auto=y
+process-max=4
+
+[global:archive-push]
+compress-level=3
+`
+
+var _ = Describe("pgbackrest.conf", func() {
+	var conf pgbackrest.Conf
+
+	option := func(conf pgbackrest.Conf, section, key string) string {
+		value, found := conf.Get(section, key)
+		Expect(found).To(BeTrue(), "option %s is missing in section %s", key, section)
+		return value
+	}
+
+	BeforeEach(func() {
+		var err error
+		conf, err = pgbackrest.ParseConf(deployedConf)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("has the sections", func() {
+		Expect(conf.Sections).To(HaveLen(3))
+		Expect(conf.Sections[0].Name).To(Equal("pitr"))
+		Expect(conf.Sections[1].Name).To(Equal(pgbackrest.GlobalSection))
+		Expect(conf.Sections[2].Name).To(Equal(pgbackrest.CommandSection(pgbackrest.GlobalSection, "archive-push")))
+	})
+
+	It("has the options", func() {
+		Expect(option(conf, "pitr", "pg1-path")).To(Equal("/var/lib/postgresql/11/main"))
+		Expect(option(conf, "pitr", "recovery-option")).To(Equal("recovery_target_action=promote"))
+		Expect(option(conf, "global", "process-max")).To(Equal("4"))
+		Expect(option(conf, "global:archive-push", "compress-level")).To(Equal("3"))
+	})
+
+	It("knows about missing options", func() {
+		_, found := conf.Get("global", "repo1-cipher-pass")
+		Expect(found).To(BeFalse())
+		_, found = conf.Get("other", "pg1-path")
+		Expect(found).To(BeFalse())
+	})
+
+	It("keeps repeated options", func() {
+		conf.Add("pitr", "recovery-option", "recovery_target_timeline=latest")
+		Expect(conf.Values("pitr", "recovery-option")).To(Equal([]string{"recovery_target_action=promote", "recovery_target_timeline=latest"}))
+	})
+
+	It("replaces options", func() {
+		conf.Add("pitr", "recovery-option", "recovery_target_timeline=latest")
+		conf.Set("pitr", "recovery-option", "standby_mode=on")
+		Expect(conf.Values("pitr", "recovery-option")).To(Equal([]string{"standby_mode=on"}))
+	})
+
+	It("removes options", func() {
+		conf.Unset("global", "stop-auto")
+		_, found := conf.Get("global", "stop-auto")
+		Expect(found).To(BeFalse())
+	})
+
+	It("adds sections as needed", func() {
+		conf.Set("pitr:restore", "process-max", "8")
+		Expect(conf.Sections).To(HaveLen(4))
+		Expect(option(conf, "pitr:restore", "process-max")).To(Equal("8"))
+	})
+
+	It("survives a round trip", func() {
+		rendered, err := pgbackrest.ParseConf(conf.String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered).To(Equal(conf))
+	})
+
+	It("renders INI", func() {
+		Expect(conf.String()).To(HavePrefix("[pitr]\npg1-path=/var/lib/postgresql/11/main\nrecovery-option=recovery_target_action=promote\n\n[global]\n"))
+	})
+
+	It("rejects options outside of a section", func() {
+		_, err := pgbackrest.ParseConf("process-max=4\n[global]\n")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects lines that are not options", func() {
+		_, err := pgbackrest.ParseConf("[global]\nprocess-max\n")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("comparing", func() {
+		It("finds no difference to itself", func() {
+			Expect(conf.Diff(conf)).To(BeEmpty())
+		})
+
+		It("finds changed, missing and unexpected options", func() {
+			actual, err := pgbackrest.ParseConf(deployedConf)
+			Expect(err).NotTo(HaveOccurred())
+
+			actual.Set("global", "process-max", "2")
+			actual.Unset("global", "stop-auto")
+			actual.Set("global", "log-level-console", "debug")
+
+			Expect(conf.Diff(actual)).To(Equal([]pgbackrest.ConfDifference{
+				{Section: "global", Key: "stop-auto", Expected: "y", Actual: ""},
+				{Section: "global", Key: "process-max", Expected: "4", Actual: "2"},
+				{Section: "global", Key: "log-level-console", Expected: "", Actual: "debug"},
+			}))
+		})
+
+		It("ignores the order of repeated options", func() {
+			expected := pgbackrest.Conf{}
+			expected.Add("pitr", "recovery-option", "a=1")
+			expected.Add("pitr", "recovery-option", "b=2")
+
+			actual := pgbackrest.Conf{}
+			actual.Add("pitr", "recovery-option", "b=2")
+			actual.Add("pitr", "recovery-option", "a=1")
+
+			Expect(expected.Diff(actual)).To(BeEmpty())
+		})
+	})
+
+	Context("derived from config.yml", func() {
+		var config cfg.Config
+
+		BeforeEach(func() {
+			var err error
+			config, err = config.FromFile("../config.yml")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("configures the minio repository of the master", func() {
+			expected, err := pgbackrest.ExpectedConf(config, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(option(expected, "pitr", "pg1-path")).To(Equal("/var/lib/postgresql/11/main"))
+			Expect(option(expected, "global", "repo1-type")).To(Equal("s3"))
+			Expect(option(expected, "global", "repo1-s3-host")).To(Equal("postgres-backup.minio.local"))
+			Expect(option(expected, "global", "repo1-path")).To(Equal("/pitr-repo"))
+			Expect(option(expected, "global", "repo1-s3-key")).To(Equal(config.Minio.AccessKey))
+			Expect(option(expected, "global", "repo1-cipher-pass")).To(Equal(config.PgBackRest.CipherPass))
+			Expect(option(expected, "global", "repo1-cipher-type")).To(Equal("aes-256-cbc"))
+			Expect(option(expected, "global:archive-push", "compress-level")).To(Equal("3"))
+		})
+
+		It("configures the standby", func() {
+			expected, err := pgbackrest.ExpectedConf(config, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(option(expected, "pitr", "recovery-option")).To(Equal("standby_mode=on"))
+			Expect(expected.Values("global:archive-push", "compress-level")).To(BeEmpty())
+		})
+
+		It("configures a local repository", func() {
+			config.PgBackRest.ArchiveType = "local"
+			config.PgBackRest.CipherPass = ""
+
+			expected, err := pgbackrest.ExpectedConf(config, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(expected.Diff(conf)).To(BeEmpty())
+		})
+
+		Context("with an S3 repository", func() {
+			original := make(map[string]*string)
+
+			BeforeEach(func() {
+				config.PgBackRest.ArchiveType = "s3"
+
+				for _, name := range []string{"S3_ACCESS_KEY", "S3_SECRET_KEY"} {
+					if value, found := os.LookupEnv(name); found {
+						original[name] = &value
+					} else {
+						original[name] = nil
+					}
+				}
+
+				os.Setenv("S3_ACCESS_KEY", "AKIAEXAMPLE")
+				os.Setenv("S3_SECRET_KEY", "secret")
+			})
+
+			AfterEach(func() {
+				for name, value := range original {
+					if value == nil {
+						os.Unsetenv(name)
+					} else {
+						os.Setenv(name, *value)
+					}
+				}
+			})
+
+			It("takes the credentials from the environment", func() {
+				expected, err := pgbackrest.ExpectedConf(config, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(option(expected, "global", "repo1-s3-key")).To(Equal("AKIAEXAMPLE"))
+				Expect(option(expected, "global", "repo1-s3-key-secret")).To(Equal("secret"))
+			})
+
+			It("rejects a missing access key", func() {
+				os.Unsetenv("S3_ACCESS_KEY")
+				_, err := pgbackrest.ExpectedConf(config, false)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("rejects an empty secret key", func() {
+				os.Setenv("S3_SECRET_KEY", "")
+				_, err := pgbackrest.ExpectedConf(config, false)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		It("rejects an unknown archive type", func() {
+			config.PgBackRest.ArchiveType = "tape"
+			_, err := pgbackrest.ExpectedConf(config, false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("deployed", func() {
+		var runner *h.FakeRunner
+		var ctl pgbackrest.Controller
+
+		BeforeEach(func() {
+			runner, ctl = fakeController("11", "")
+			runner.On("cat /etc/pgbackrest.conf", deployedConf, "", nil)
+		})
+
+		It("reads the deployed file", func() {
+			deployed, err := ctl.DeployedConf()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deployed).To(Equal(conf))
+		})
+
+		It("lists the corrections", func() {
+			expected, _ := pgbackrest.ParseConf(deployedConf)
+			expected.Set("global", "process-max", "8")
+
+			differences, err := ctl.DiffConf(expected)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(differences).To(ConsistOf(pgbackrest.ConfDifference{Section: "global", Key: "process-max", Expected: "8", Actual: "4"}))
+		})
+
+		It("pushes the corrected file", func() {
+			conf.Set("global", "repo1-cipher-pass", "it's secret")
+
+			Expect(ctl.PushConf(conf)).NotTo(HaveOccurred())
+			Expect(runner.Commands).To(ConsistOf("sudo --user postgres tee /etc/pgbackrest.conf > /dev/null"))
+			Expect(runner.Inputs[0]).To(And(
+				HavePrefix("[pitr]\npg1-path="),
+				ContainSubstring("repo1-cipher-pass=it's secret"),
+			))
+		})
+	})
+})
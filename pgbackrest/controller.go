@@ -1,7 +1,6 @@
 package pgbackrest
 
 import (
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,206 +9,143 @@ import (
 	pitr "github.com/suhlig/postgres-pitr"
 )
 
-// Info tells about the backups of a stanza
-type Info struct {
-	Name   string
-	Status struct {
-		Code    int
-		Message string
-	}
-}
-
 // Controller provides a way to control pgbackrest
 type Controller struct {
 	runner  pitr.Runner
 	cluster cluster.Controller
+	standby *Standby
+
+	// PollInterval is the time between two checks while waiting
+	PollInterval time.Duration
 }
 
 // NewController creates a new controller
 func NewController(runner pitr.Runner, cluster cluster.Controller) Controller {
 	return Controller{
-		runner:  runner,
-		cluster: cluster,
+		runner:       runner,
+		cluster:      cluster,
+		PollInterval: time.Second,
 	}
 }
 
-// Info provides a summary of backups for the given stanza
-func (ctl Controller) Info(stanza string) ([]Info, *pitr.Error) {
-	stdout, stderr, err := ctl.runner.Run("sudo --user postgres pgbackrest info --stanza=%s --output=json", stanza)
+// Info provides a summary of backups for the given stanza in all repositories
+func (ctl Controller) Info(stanza string) ([]Info, *Error) {
+	return ctl.InfoForRepo(stanza, 0)
+}
 
-	if err != nil {
-		return nil, &pitr.Error{
-			Message: err.Error(),
-			Stdout:  stdout,
-			Stderr:  stderr,
-		}
+// InfoForRepo provides a summary of backups for the given stanza in the repository with the given key.
+// If repo is 0, all repositories are included.
+func (ctl Controller) InfoForRepo(stanza string, repo int) ([]Info, *Error) {
+	var repoArg string
+
+	if repo > 0 {
+		repoArg = fmt.Sprintf(" --repo=%d", repo)
 	}
 
-	info, err := parseInfo(stdout)
+	stdout, stderr, err := ctl.runner.Run("sudo --user postgres pgbackrest info --stanza=%s --output=json%s", stanza, repoArg)
 
 	if err != nil {
-		return nil, &pitr.Error{
-			Message: err.Error(),
-			Stdout:  stdout,
-			Stderr:  stderr,
-		}
+		return nil, newError(stdout, stderr, err)
 	}
 
-	return info, nil
-}
-
-// Backup creates a new backup for the given stanza
-func (ctl Controller) Backup(stanza string) *pitr.Error {
-	stdout, stderr, err := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s backup --type=incr", stanza)
+	info, err := ParseInfo(stdout)
 
 	if err != nil {
-		return &pitr.Error{
+		return nil, &Error{
 			Message: err.Error(),
 			Stdout:  stdout,
 			Stderr:  stderr,
 		}
 	}
 
-	return nil
+	return info, nil
 }
 
-// Restore a backup for the given stanza
-func (ctl Controller) Restore(stanza string) *pitr.Error {
-	err := ctl.cluster.Stop()
+// ValidateVersion makes sure that the backups of the given stanza were made with the same major version as the cluster is running
+func (ctl Controller) ValidateVersion(stanza string) *Error {
+	info, err := ctl.stanzaInfo(stanza)
 
 	if err != nil {
 		return err
 	}
 
-	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s --delta restore", stanza)
+	database := info.CurrentDatabase()
 
-	if runErr != nil {
-		return &pitr.Error{
-			Message: runErr.Error(),
-			Stdout:  stdout,
-			Stderr:  stderr,
+	if database == nil {
+		return &Error{
+			Message: fmt.Sprintf("Stanza %s has no backups", stanza),
 		}
 	}
 
-	err = ctl.cluster.Start()
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return ctl.validateVersion(stanza, *database)
 }
 
-// RestoreToPIT a specific point in time
-func (ctl Controller) RestoreToPIT(stanza string, pointInTime time.Time) *pitr.Error {
-	err := ctl.cluster.Stop()
+// validateVersion makes sure that the given database of the stanza has the same major version as the cluster
+func (ctl Controller) validateVersion(stanza string, database InfoDatabase) *Error {
 
-	if err != nil {
-		return err
-	}
+	backupVersion, parseErr := pitr.ParseVersion(database.Version)
 
-	stdout, stderr, runErr := ctl.runner.Run(
-		"sudo --user postgres pgbackrest"+
-			" --stanza=%s"+
-			" --delta"+
-			" --type=time"+
-			" --target=\"%s\""+
-			" restore",
-		stanza,
-		fmt.Sprintf((pointInTime.Format(time.RFC3339Nano))),
-	)
-
-	if runErr != nil {
-		return &pitr.Error{
-			Message: runErr.Error(),
-			Stdout:  stdout,
-			Stderr:  stderr,
+	if parseErr != nil {
+		return &Error{
+			Message: parseErr.Error(),
 		}
 	}
 
-	err = ctl.cluster.Start()
+	clusterVersion, parseErr := pitr.ParseVersion(ctl.cluster.Version)
 
-	if err != nil {
-		return err
+	if parseErr != nil {
+		return &Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	if !backupVersion.SameMajor(clusterVersion) {
+		return &Error{
+			Message: fmt.Sprintf("Stanza %s has backups of version %s, but the cluster runs version %s", stanza, backupVersion.MajorString(), clusterVersion.MajorString()),
+		}
 	}
 
 	return nil
 }
 
-// RestoreToSavePoint restores to the given savepoint
-func (ctl Controller) RestoreToSavePoint(stanza string, savePoint string) *pitr.Error {
-	err := ctl.cluster.Stop()
+// stanzaInfo returns the info of the given stanza
+func (ctl Controller) stanzaInfo(stanza string) (*Info, *Error) {
+	infos, err := ctl.Info(stanza)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	stdout, stderr, runErr := ctl.runner.Run(
-		"sudo --user postgres pgbackrest"+
-			" --stanza=%s"+
-			" --delta"+
-			" --type=name"+
-			" --target=\"%s\""+
-			" restore",
-		stanza,
-		savePoint,
-	)
-
-	if runErr != nil {
-		return &pitr.Error{
-			Message: runErr.Error(),
-			Stdout:  stdout,
-			Stderr:  stderr,
+	for i, info := range infos {
+		if info.Name == stanza {
+			return &infos[i], nil
 		}
 	}
 
-	err = ctl.cluster.Start()
-
-	if err != nil {
-		return err
+	return nil, &Error{
+		Message: fmt.Sprintf("Stanza %s does not exist", stanza),
 	}
-
-	return nil
 }
 
-// RestoreToTransactionID restores to the given savepoint
-func (ctl Controller) RestoreToTransactionID(stanza string, txID int64) *pitr.Error {
-	err := ctl.cluster.Stop()
+// waitFor checks the condition until it is met or the timeout has passed
+func (ctl Controller) waitFor(timeout time.Duration, condition func() (bool, *Error), message string) *Error {
+	deadline := time.Now().Add(timeout)
 
-	if err != nil {
-		return err
-	}
+	for {
+		done, err := condition()
 
-	stdout, stderr, runErr := ctl.runner.Run(
-		"sudo --user postgres pgbackrest"+
-			" --stanza=%s"+
-			" --delta"+
-			" --type=xid"+
-			" --target=\"%d\""+
-			" restore",
-		stanza,
-		txID,
-	)
-
-	if runErr != nil {
-		return &pitr.Error{
-			Message: runErr.Error(),
-			Stdout:  stdout,
-			Stderr:  stderr,
+		if err != nil {
+			return err
 		}
-	}
 
-	err = ctl.cluster.Start()
-
-	if err != nil {
-		return err
-	}
+		if done {
+			return nil
+		}
 
-	return nil
-}
+		if time.Now().After(deadline) {
+			return &Error{Message: message}
+		}
 
-func parseInfo(stdout string) ([]Info, error) {
-	infos := make([]Info, 0)
-	err := json.Unmarshal([]byte(stdout), &infos)
-	return infos, err
+		time.Sleep(ctl.PollInterval)
+	}
 }
@@ -0,0 +1,186 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"path"
+
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+)
+
+// Copy is a cluster restored from a backup next to the original one, e.g. for inspecting yesterday's data
+type Copy struct {
+	Cluster cluster.Controller
+	Port    int
+}
+
+// RestoreCopy restores a backup of the given stanza into a new cluster with the given name, leaving the
+// original cluster alone. The copy gets its own data directory and a fresh port, and it is started read-only:
+// without a recovery target it runs as a standby of the repository; otherwise it pauses at the target.
+// It never archives WAL into the stanza's repository, and its tablespaces go below TablespaceDirectory
+// instead of overwriting those of the original. To restore on a different host, use a Controller
+// with a Runner for that host.
+func (ctl Controller) RestoreCopy(stanza, name string, options RestoreOptions) (*Copy, *Error) {
+	if name == ctl.cluster.Name {
+		return nil, &Error{
+			Message: fmt.Sprintf("The copy must not have the same name as the original cluster %s", name),
+		}
+	}
+
+	switch options.TargetAction {
+	case "", TargetActionPause:
+	default:
+		return nil, &Error{
+			Message: fmt.Sprintf("A copy is read-only; target action %s is not supported", options.TargetAction),
+			Code:    ErrorCodeOptionInvalid,
+		}
+	}
+
+	switch options.Type {
+	case "", TargetTypeDefault:
+		options.Type = TargetTypeStandby
+	default:
+		options.TargetAction = TargetActionPause
+	}
+
+	// the data directory of a new cluster is emptied anyway
+	options.Clean = true
+
+	args, excluded, err := ctl.prepareRestore(stanza, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	version, parseErr := pitr.ParseVersion(ctl.cluster.Version)
+
+	if parseErr != nil {
+		return nil, &Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	if version.Compare(pitr.FirstVersionWithArchiveModeOff) >= 0 {
+		args += " --archive-mode=off"
+	}
+
+	copyCluster := cluster.NewController(ctl.runner, ctl.cluster.Version, name)
+
+	exists, pitrErr := copyCluster.Exists()
+
+	if pitrErr != nil {
+		return nil, fromPitrError(pitrErr)
+	}
+
+	if exists {
+		return nil, &Error{
+			Message: fmt.Sprintf("Cluster %s/%s already exists", copyCluster.Version, copyCluster.Name),
+		}
+	}
+
+	err = fromPitrError(copyCluster.Create())
+
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := ctl.fillCopy(copyCluster, version, stanza, args, excluded)
+
+	if err != nil {
+		// a half-restored copy is of no use, and its name would block the next attempt
+		if dropErr := ctl.dropCopy(copyCluster); dropErr != nil {
+			err.Message = fmt.Sprintf("%s; could not drop the copy %s/%s either: %s", err.Message, copyCluster.Version, copyCluster.Name, dropErr.Message)
+		}
+
+		return nil, err
+	}
+
+	return &Copy{
+		Cluster: copyCluster,
+		Port:    port,
+	}, nil
+}
+
+// fillCopy restores into the freshly created copy, starts it and returns its port
+func (ctl Controller) fillCopy(copyCluster cluster.Controller, version pitr.Version, stanza, args string, excluded []string) (int, *Error) {
+	err := fromPitrError(copyCluster.Clear())
+
+	if err != nil {
+		return 0, err
+	}
+
+	tablespaces := TablespaceDirectory(copyCluster)
+	stdout, stderr, runErr := ctl.runner.Run("sudo install --directory --owner=postgres --group=postgres --mode=700 %s", shellQuote(tablespaces))
+
+	if runErr != nil {
+		return 0, newError(stdout, stderr, runErr)
+	}
+
+	stdout, stderr, runErr = ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s --pg1-path=%s --tablespace-map-all=%s%s restore", stanza, copyCluster.DataDirectory(), shellQuote(tablespaces), args)
+
+	if runErr != nil {
+		return 0, newError(stdout, stderr, runErr)
+	}
+
+	// hot_standby lives in postgresql.conf, not in recovery.conf, so pgbackrest cannot set it
+	if version.Compare(pitr.FirstVersionWithHotStandbyOn) < 0 {
+		err = fromPitrError(copyCluster.Configure("hot_standby", "on"))
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	port, pitrErr := copyCluster.Port()
+
+	if pitrErr != nil {
+		return 0, fromPitrError(pitrErr)
+	}
+
+	err = fromPitrError(copyCluster.Start())
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = ctl.verifyExcluded(copyCluster, excluded)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return port, nil
+}
+
+// DropCopy stops the copy and removes it
+func (ctl Controller) DropCopy(target Copy) *Error {
+	if target.Cluster.Version == ctl.cluster.Version && target.Cluster.Name == ctl.cluster.Name {
+		return &Error{
+			Message: fmt.Sprintf("Refusing to drop the original cluster %s/%s", ctl.cluster.Version, ctl.cluster.Name),
+		}
+	}
+
+	return ctl.dropCopy(target.Cluster)
+}
+
+// TablespaceDirectory is where the tablespaces of a copy are restored to, next to its data directory
+func TablespaceDirectory(copyCluster cluster.Controller) string {
+	return path.Join(path.Dir(copyCluster.DataDirectory()), "tablespaces", copyCluster.Name)
+}
+
+// dropCopy drops the cluster of a copy and removes its tablespaces, which pg_dropcluster leaves behind
+func (ctl Controller) dropCopy(copyCluster cluster.Controller) *Error {
+	err := fromPitrError(copyCluster.Drop())
+
+	if err != nil {
+		return err
+	}
+
+	stdout, stderr, runErr := ctl.runner.Run("sudo rm --recursive --force %s", shellQuote(TablespaceDirectory(copyCluster)))
+
+	if runErr != nil {
+		return newError(stdout, stderr, runErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package pgbackrest_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Restoring a copy", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var options pgbackrest.RestoreOptions
+	var copy *pgbackrest.Copy
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("13", "info-2.33.json")
+		runner.On("test -d /etc/postgresql/13/yesterday", "", "", h.ExitError{Status: 1})
+		runner.On("pg_lsclusters", "13  yesterday 5433 down postgres /var/lib/postgresql/13/yesterday /var/log/postgresql/postgresql-13-yesterday.log\n", "", nil)
+		options = pgbackrest.RestoreOptions{}
+	})
+
+	JustBeforeEach(func() {
+		copy, err = ctl.RestoreCopy("pitr", "yesterday", options)
+	})
+
+	It("creates a new cluster", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(copy.Cluster.Name).To(Equal("yesterday"))
+		Expect(copy.Cluster.Version).To(Equal("13"))
+		Expect(copy.Port).To(Equal(5433))
+		Expect(runner.CommandsContaining("pg_createcluster 13 yesterday")).To(HaveLen(1))
+	})
+
+	It("restores into the data directory of the copy as a standby that does not archive", func() {
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --pg1-path=/var/lib/postgresql/13/yesterday --tablespace-map-all='/var/lib/postgresql/13/tablespaces/yesterday' --type=standby --archive-mode=off restore"))
+	})
+
+	It("restores the tablespaces next to the copy instead of over those of the original", func() {
+		Expect(pgbackrest.TablespaceDirectory(copy.Cluster)).To(Equal("/var/lib/postgresql/13/tablespaces/yesterday"))
+		Expect(runner.CommandsContaining("install --directory")).To(ConsistOf("sudo install --directory --owner=postgres --group=postgres --mode=700 '/var/lib/postgresql/13/tablespaces/yesterday'"))
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf(ContainSubstring("--tablespace-map-all='/var/lib/postgresql/13/tablespaces/yesterday'")))
+	})
+
+	It("starts the copy", func() {
+		Expect(runner.CommandsContaining("pg_ctlcluster 13 yesterday start")).To(HaveLen(1))
+	})
+
+	It("does not touch the original cluster", func() {
+		Expect(runner.CommandsContaining("13 main")).To(BeEmpty())
+		Expect(runner.CommandsContaining("/13/main")).To(BeEmpty())
+	})
+
+	Context("recovering to a point in time", func() {
+		BeforeEach(func() {
+			options.Type = pgbackrest.TargetTypeTime
+			options.TargetTime = time.Date(2021, 4, 12, 12, 0, 0, 0, time.UTC)
+		})
+
+		It("pauses at the target", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("restore")).To(ConsistOf(`sudo --user postgres pgbackrest --stanza=pitr --pg1-path=/var/lib/postgresql/13/yesterday --tablespace-map-all='/var/lib/postgresql/13/tablespaces/yesterday' --type=time --target='2021-04-12T12:00:00Z' --target-action=pause --archive-mode=off restore`))
+		})
+	})
+
+	Context("the copy would be promoted", func() {
+		BeforeEach(func() {
+			options.Type = pgbackrest.TargetTypeImmediate
+			options.TargetAction = pgbackrest.TargetActionPromote
+		})
+
+		It("is rejected", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.Commands).To(BeEmpty())
+		})
+	})
+
+	Context("the cluster exists already", func() {
+		BeforeEach(func() {
+			runner.On("test -d /etc/postgresql/13/yesterday", "", "", nil)
+		})
+
+		It("does not restore", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_createcluster")).To(BeEmpty())
+			Expect(runner.CommandsContaining("restore")).To(BeEmpty())
+		})
+	})
+
+	Context("the restore fails", func() {
+		BeforeEach(func() {
+			runner.On("restore", "", "ERROR: [075]: no backup set found to restore", h.ExitError{Status: 75})
+		})
+
+		It("drops the half-restored copy", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_dropcluster --stop 13 yesterday")).To(HaveLen(1))
+			Expect(runner.CommandsContaining("pg_ctlcluster 13 yesterday start")).To(BeEmpty())
+		})
+	})
+
+	Context("the copy does not start", func() {
+		BeforeEach(func() {
+			runner.On("pg_ctlcluster 13 yesterday start", "", "The PostgreSQL server failed to start.", h.ExitError{Status: 1})
+		})
+
+		It("drops the copy", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_dropcluster --stop 13 yesterday")).To(HaveLen(1))
+		})
+	})
+
+	Context("the copy has the name of the original", func() {
+		JustBeforeEach(func() {
+			copy, err = ctl.RestoreCopy("pitr", "main", options)
+		})
+
+		It("is rejected", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("PostgreSQL 11", func() {
+		BeforeEach(func() {
+			runner.On("pgbackrest info", readFixture("info-2.19.json"), "", nil)
+			runner.On("test -d /etc/postgresql/11/yesterday", "", "", h.ExitError{Status: 1})
+			runner.On("pg_lsclusters", "11  yesterday 5433 down postgres /var/lib/postgresql/11/yesterday /var/log/postgresql/postgresql-11-yesterday.log\n", "", nil)
+
+			ctl = pgbackrest.NewController(runner, cluster.NewController(runner, "11", "main"))
+		})
+
+		It("cannot disable archiving, but relies on recovery not finishing", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_conftool")).To(BeEmpty())
+			Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --pg1-path=/var/lib/postgresql/11/yesterday --tablespace-map-all='/var/lib/postgresql/11/tablespaces/yesterday' --type=standby restore"))
+		})
+	})
+
+	Context("PostgreSQL 9.6", func() {
+		BeforeEach(func() {
+			runner.On("pgbackrest info", strings.Replace(readFixture("info-2.19.json"), `"version": "11"`, `"version": "9.6"`, -1), "", nil)
+			runner.On("test -d /etc/postgresql/9.6/yesterday", "", "", h.ExitError{Status: 1})
+			runner.On("pg_lsclusters", "9.6 yesterday 5433 down postgres /var/lib/postgresql/9.6/yesterday /var/log/postgresql/postgresql-9.6-yesterday.log\n", "", nil)
+
+			ctl = pgbackrest.NewController(runner, cluster.NewController(runner, "9.6", "main"))
+		})
+
+		It("allows read-only queries before starting the copy", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CommandsContaining("sudo pg_c")).To(Equal([]string{
+				"sudo pg_createcluster 9.6 yesterday",
+				"sudo pg_conftool 9.6 yesterday set hot_standby on",
+				"sudo pg_ctlcluster 9.6 yesterday start",
+			}))
+		})
+	})
+
+	Context("tearing down", func() {
+		It("drops the copy", func() {
+			Expect(ctl.DropCopy(*copy)).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_dropcluster --stop 13 yesterday")).To(HaveLen(1))
+			Expect(runner.CommandsContaining("rm --recursive --force")).To(ConsistOf("sudo rm --recursive --force '/var/lib/postgresql/13/tablespaces/yesterday'"))
+		})
+
+		It("does not drop the original", func() {
+			Expect(ctl.DropCopy(pgbackrest.Copy{Cluster: cluster.NewController(runner, "13", "main")})).To(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_dropcluster")).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,141 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/suhlig/postgres-pitr/cluster"
+	"github.com/suhlig/postgres-pitr/config"
+)
+
+// ExpectedConf derives the pgbackrest configuration of the master (or the standby) from the project configuration,
+// matching what the Ansible templates deploy. Credentials for S3 are taken from S3_ACCESS_KEY and S3_SECRET_KEY,
+// which must both be set; the cipher pass is resolved by config.RepoCipherPass. The repository has the key and,
+// if given, the path from the configuration, so that it still matches after rotating the cipher pass.
+func ExpectedConf(cfg config.Config, standby bool) (Conf, error) {
+	conf := Conf{}
+	stanza := cfg.PgBackRest.Stanza
+	repo := cfg.RepoKey()
+
+	if standby {
+		conf.Set(stanza, "pg1-path", cluster.NewController(nil, cfg.Standby.Version, cfg.Standby.ClusterName).DataDirectory())
+		conf.Set(stanza, "recovery-option", "standby_mode=on")
+	} else {
+		conf.Set(stanza, "pg1-path", cluster.NewController(nil, cfg.Master.Version, cfg.Master.ClusterName).DataDirectory())
+		conf.Set(stanza, "recovery-option", "recovery_target_action=promote")
+	}
+
+	switch cfg.PgBackRest.ArchiveType {
+	case "", "local":
+		conf.Set(GlobalSection, repoOption(repo, "path"), defaultRepoPath)
+	case "s3":
+		accessKey, secretKey := os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY")
+
+		if "" == accessKey || "" == secretKey {
+			return conf, fmt.Errorf("Archive type s3 requires the credentials in S3_ACCESS_KEY and S3_SECRET_KEY")
+		}
+
+		conf.Set(GlobalSection, repoOption(repo, "type"), "s3")
+		conf.Set(GlobalSection, repoOption(repo, "s3-endpoint"), "s3.amazonaws.com")
+		conf.Set(GlobalSection, repoOption(repo, "s3-bucket"), cfg.PgBackRest.S3Bucket)
+		conf.Set(GlobalSection, repoOption(repo, "path"), fmt.Sprintf("/%s-repo", stanza))
+		conf.Set(GlobalSection, repoOption(repo, "s3-key"), accessKey)
+		conf.Set(GlobalSection, repoOption(repo, "s3-key-secret"), secretKey)
+		conf.Set(GlobalSection, repoOption(repo, "s3-region"), "eu-central-1")
+	case "minio":
+		conf.Set(GlobalSection, repoOption(repo, "type"), "s3")
+		conf.Set(GlobalSection, repoOption(repo, "s3-host"), fmt.Sprintf("%s.%s", cfg.PgBackRest.S3Bucket, cfg.Minio.Domain))
+		conf.Set(GlobalSection, repoOption(repo, "s3-endpoint"), cfg.Minio.Domain)
+		conf.Set(GlobalSection, repoOption(repo, "s3-verify-ssl"), "n")
+		conf.Set(GlobalSection, repoOption(repo, "s3-bucket"), cfg.PgBackRest.S3Bucket)
+		conf.Set(GlobalSection, repoOption(repo, "path"), fmt.Sprintf("/%s-repo", stanza))
+		conf.Set(GlobalSection, repoOption(repo, "s3-key"), cfg.Minio.AccessKey)
+		conf.Set(GlobalSection, repoOption(repo, "s3-key-secret"), cfg.Minio.SecretKey)
+		conf.Set(GlobalSection, repoOption(repo, "s3-region"), "us-east-1")
+	default:
+		return conf, fmt.Errorf("Unknown archive type '%s'; expected local, s3 or minio", cfg.PgBackRest.ArchiveType)
+	}
+
+	if "" != cfg.PgBackRest.RepoPath {
+		conf.Set(GlobalSection, repoOption(repo, "path"), cfg.PgBackRest.RepoPath)
+	}
+
+	conf.Set(GlobalSection, repoOption(repo, "retention-full"), "2")
+	conf.Set(GlobalSection, repoOption(repo, "retention-diff"), "2")
+
+	cipherPass, err := cfg.RepoCipherPass()
+
+	if err != nil {
+		return conf, err
+	}
+
+	if "" == cipherPass {
+		conf.Set(GlobalSection, repoOption(repo, "cipher-type"), "none")
+	} else {
+		conf.Set(GlobalSection, repoOption(repo, "cipher-pass"), cipherPass)
+		conf.Set(GlobalSection, repoOption(repo, "cipher-type"), "aes-256-cbc")
+	}
+
+	conf.Set(GlobalSection, "start-fast", "y")
+	conf.Set(GlobalSection, "stop-auto", "y")
+	conf.Set(GlobalSection, "process-max", "4")
+
+	if !standby {
+		conf.Set(CommandSection(GlobalSection, "archive-push"), "compress-level", "3")
+	}
+
+	return conf, nil
+}
+
+// DeployedConf reads the configuration pgbackrest currently uses
+func (ctl Controller) DeployedConf() (Conf, *Error) {
+	stdout, stderr, err := ctl.runner.Run("cat %s", ConfPath)
+
+	if err != nil {
+		return Conf{}, &Error{
+			Message: fmt.Sprintf("Could not read %s: %s", ConfPath, err),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	conf, parseErr := ParseConf(stdout)
+
+	if parseErr != nil {
+		return Conf{}, &Error{
+			Message: parseErr.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+			Code:    ErrorCodeConfig,
+		}
+	}
+
+	return conf, nil
+}
+
+// DiffConf lists the options of the deployed configuration that differ from the expected one
+func (ctl Controller) DiffConf(expected Conf) ([]ConfDifference, *Error) {
+	deployed, err := ctl.DeployedConf()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return expected.Diff(deployed), nil
+}
+
+// PushConf replaces the deployed configuration with the given one. The configuration holds secrets,
+// so it is passed on stdin rather than on the command line.
+func (ctl Controller) PushConf(conf Conf) *Error {
+	stdout, stderr, err := ctl.runner.RunWithInput(conf.String(), "sudo --user postgres tee %s > /dev/null", ConfPath)
+
+	if err != nil {
+		return &Error{
+			Message: fmt.Sprintf("Could not write %s: %s", ConfPath, err),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,288 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+// Error codes pgbackrest reports as "ERROR: [nnn]". They are also its exit codes.
+const (
+	ErrorCodeAssert                = 25
+	ErrorCodeChecksum              = 26
+	ErrorCodeConfig                = 27
+	ErrorCodeFileInvalid           = 28
+	ErrorCodeFormat                = 29
+	ErrorCodeCommandRequired       = 30
+	ErrorCodeOptionInvalid         = 31
+	ErrorCodeOptionInvalidValue    = 32
+	ErrorCodeOptionInvalidRange    = 33
+	ErrorCodeOptionInvalidPair     = 34
+	ErrorCodeOptionDuplicate       = 35
+	ErrorCodeOptionNegate          = 36
+	ErrorCodeOptionRequired        = 37
+	ErrorCodePgRunning             = 38
+	ErrorCodeProtocol              = 39
+	ErrorCodePathNotEmpty          = 40
+	ErrorCodeFileOpen              = 41
+	ErrorCodeFileRead              = 42
+	ErrorCodeParamRequired         = 43
+	ErrorCodeArchiveMismatch       = 44
+	ErrorCodeArchiveDuplicate      = 45
+	ErrorCodeVersionNotSupported   = 46
+	ErrorCodePathCreate            = 47
+	ErrorCodeCommandInvalid        = 48
+	ErrorCodeHostConnect           = 49
+	ErrorCodeLockAcquire           = 50
+	ErrorCodeBackupMismatch        = 51
+	ErrorCodeFileSync              = 52
+	ErrorCodePathOpen              = 53
+	ErrorCodePathSync              = 54
+	ErrorCodeFileMissing           = 55
+	ErrorCodeDbConnect             = 56
+	ErrorCodeDbQuery               = 57
+	ErrorCodeDbMismatch            = 58
+	ErrorCodeDbTimeout             = 59
+	ErrorCodeFileRemove            = 60
+	ErrorCodePathRemove            = 61
+	ErrorCodeStop                  = 62
+	ErrorCodeTerm                  = 63
+	ErrorCodeFileWrite             = 64
+	ErrorCodeProtocolTimeout       = 66
+	ErrorCodeFeatureNotSupported   = 67
+	ErrorCodeArchiveCommandInvalid = 68
+	ErrorCodeLinkExpected          = 69
+	ErrorCodeLinkDestination       = 70
+	ErrorCodeHostInvalid           = 72
+	ErrorCodePathMissing           = 73
+	ErrorCodeFileMove              = 74
+	ErrorCodeBackupSetInvalid      = 75
+	ErrorCodeTablespaceMap         = 76
+	ErrorCodePathType              = 77
+	ErrorCodeLinkMap               = 78
+	ErrorCodeFileClose             = 79
+	ErrorCodeDbMissing             = 80
+	ErrorCodeDbInvalid             = 81
+	ErrorCodeArchiveTimeout        = 82
+	ErrorCodeFileMode              = 83
+	ErrorCodeOptionMultipleValue   = 84
+	ErrorCodeArchiveDisabled       = 87
+	ErrorCodeFileOwner             = 88
+	ErrorCodeUserMissing           = 89
+	ErrorCodeOptionCommand         = 90
+	ErrorCodeGroupMissing          = 91
+	ErrorCodePathExists            = 92
+	ErrorCodeFileExists            = 93
+	ErrorCodeMemory                = 94
+	ErrorCodeCrypto                = 95
+	ErrorCodeParamInvalid          = 96
+	ErrorCodeRepoInvalid           = 103
+	ErrorCodeCommand               = 104
+	ErrorCodeAccess                = 105
+)
+
+type errorDescription struct {
+	name string
+	hint string
+}
+
+var errorDescriptions = map[int]errorDescription{
+	ErrorCodeAssert:                {"AssertError", "This is a bug in pgbackrest."},
+	ErrorCodeChecksum:              {"ChecksumError", "A file in the repository is corrupt; verify the repository."},
+	ErrorCodeConfig:                {"ConfigError", "Check pgbackrest.conf for syntax errors."},
+	ErrorCodeFileInvalid:           {"FileInvalidError", "The repository does not belong to this cluster, or it is corrupt."},
+	ErrorCodeFormat:                {"FormatError", "A file has an unexpected format; check the repository and the cipher settings."},
+	ErrorCodeCommandRequired:       {"CommandRequiredError", "A pgbackrest command is missing."},
+	ErrorCodeOptionInvalid:         {"OptionInvalidError", "An option is not valid for this command."},
+	ErrorCodeOptionInvalidValue:    {"OptionInvalidValueError", "An option has an invalid value."},
+	ErrorCodeOptionInvalidRange:    {"OptionInvalidRangeError", "An option is out of its allowed range."},
+	ErrorCodeOptionInvalidPair:     {"OptionInvalidPairError", "An option expects a key=value pair."},
+	ErrorCodeOptionDuplicate:       {"OptionDuplicateError", "An option was given more than once."},
+	ErrorCodeOptionNegate:          {"OptionNegateError", "An option cannot be negated."},
+	ErrorCodeOptionRequired:        {"OptionRequiredError", "A required option is missing; check the stanza section of pgbackrest.conf."},
+	ErrorCodePgRunning:             {"PgRunningError", "Stop the cluster first."},
+	ErrorCodeProtocol:              {"ProtocolError", "Communication with a remote pgbackrest failed; check that the versions match."},
+	ErrorCodePathNotEmpty:          {"PathNotEmptyError", "Clear the target directory or use delta restore."},
+	ErrorCodeFileOpen:              {"FileOpenError", "Check that the file exists and that permissions allow access."},
+	ErrorCodeFileRead:              {"FileReadError", "Check the file system for errors."},
+	ErrorCodeParamRequired:         {"ParamRequiredError", "A required parameter is missing."},
+	ErrorCodeArchiveMismatch:       {"ArchiveMismatchError", "The archive belongs to another cluster or version; upgrade the stanza after a major upgrade."},
+	ErrorCodeArchiveDuplicate:      {"ArchiveDuplicateError", "A WAL segment with different content was archived before; another cluster may be archiving into the same stanza."},
+	ErrorCodeVersionNotSupported:   {"VersionNotSupportedError", "This PostgreSQL version is not supported by the installed pgbackrest."},
+	ErrorCodePathCreate:            {"PathCreateError", "Check permissions of the parent directory."},
+	ErrorCodeCommandInvalid:        {"CommandInvalidError", "The pgbackrest command is unknown."},
+	ErrorCodeHostConnect:           {"HostConnectError", "Check SSH connectivity between the hosts."},
+	ErrorCodeLockAcquire:           {"LockAcquireError", "Another pgbackrest process holds the lock for this stanza; wait for it to finish."},
+	ErrorCodeBackupMismatch:        {"BackupMismatchError", "The backup info does not match the cluster; the stanza may need an upgrade."},
+	ErrorCodeFileSync:              {"FileSyncError", "Check the file system for errors."},
+	ErrorCodePathOpen:              {"PathOpenError", "Check that the path exists and that permissions allow access."},
+	ErrorCodePathSync:              {"PathSyncError", "Check the file system for errors."},
+	ErrorCodeFileMissing:           {"FileMissingError", "The stanza may not exist yet; create it first."},
+	ErrorCodeDbConnect:             {"DbConnectError", "The cluster is not reachable; check that it is running and that pg1-path and pg1-port are correct."},
+	ErrorCodeDbQuery:               {"DbQueryError", "A query against the cluster failed; check its log."},
+	ErrorCodeDbMismatch:            {"DbMismatchError", "The configured path or version does not match the running cluster."},
+	ErrorCodeDbTimeout:             {"DbTimeoutError", "The cluster did not answer in time."},
+	ErrorCodeFileRemove:            {"FileRemoveError", "Check permissions."},
+	ErrorCodePathRemove:            {"PathRemoveError", "Check permissions."},
+	ErrorCodeStop:                  {"StopError", "pgbackrest was stopped for maintenance; run `pgbackrest start` to allow operations again."},
+	ErrorCodeTerm:                  {"TermError", "pgbackrest was terminated."},
+	ErrorCodeFileWrite:             {"FileWriteError", "Check for free disk space and permissions."},
+	ErrorCodeProtocolTimeout:       {"ProtocolTimeoutError", "A remote pgbackrest did not answer in time."},
+	ErrorCodeFeatureNotSupported:   {"FeatureNotSupportedError", "The requested feature is not supported by this PostgreSQL version or configuration."},
+	ErrorCodeArchiveCommandInvalid: {"ArchiveCommandInvalidError", "archive_command must call pgbackrest archive-push for this stanza."},
+	ErrorCodeLinkExpected:          {"LinkExpectedError", "A symlink was expected."},
+	ErrorCodeLinkDestination:       {"LinkDestinationError", "A symlink points to an invalid location."},
+	ErrorCodeHostInvalid:           {"HostInvalidError", "The command must run on another host."},
+	ErrorCodePathMissing:           {"PathMissingError", "A path in the repository or data directory is missing."},
+	ErrorCodeFileMove:              {"FileMoveError", "Check permissions and free disk space."},
+	ErrorCodeBackupSetInvalid:      {"BackupSetInvalidError", "The requested backup set does not exist, or no backup is able to reach the recovery target."},
+	ErrorCodeTablespaceMap:         {"TablespaceMapError", "A tablespace mapping is invalid."},
+	ErrorCodePathType:              {"PathTypeError", "A path is not a directory."},
+	ErrorCodeLinkMap:               {"LinkMapError", "A link mapping is invalid."},
+	ErrorCodeFileClose:             {"FileCloseError", "Check the file system for errors."},
+	ErrorCodeDbMissing:             {"DbMissingError", "A database selected for restore does not exist in the backup."},
+	ErrorCodeDbInvalid:             {"DbInvalidError", "A database selected for restore is invalid, e.g. a system database."},
+	ErrorCodeArchiveTimeout:        {"ArchiveTimeoutError", "WAL was not archived in time; check archive_command and the PostgreSQL log."},
+	ErrorCodeFileMode:              {"FileModeError", "A file has an unexpected mode."},
+	ErrorCodeOptionMultipleValue:   {"OptionMultipleValueError", "An option was given more than one value."},
+	ErrorCodeArchiveDisabled:       {"ArchiveDisabledError", "Enable archive_mode and set archive_command."},
+	ErrorCodeFileOwner:             {"FileOwnerError", "A file has an unexpected owner."},
+	ErrorCodeUserMissing:           {"UserMissingError", "A system user is missing."},
+	ErrorCodeOptionCommand:         {"OptionCommandError", "An option is not valid for this command."},
+	ErrorCodeGroupMissing:          {"GroupMissingError", "A system group is missing."},
+	ErrorCodePathExists:            {"PathExistsError", "A path exists already."},
+	ErrorCodeFileExists:            {"FileExistsError", "A file exists already."},
+	ErrorCodeMemory:                {"MemoryError", "pgbackrest ran out of memory."},
+	ErrorCodeCrypto:                {"CryptoError", "The repository cipher pass is probably wrong."},
+	ErrorCodeParamInvalid:          {"ParamInvalidError", "A parameter is invalid."},
+	ErrorCodeRepoInvalid:           {"RepoInvalidError", "No valid repository was found; check the repo settings."},
+	ErrorCodeCommand:               {"CommandError", "A command run by pgbackrest failed."},
+	ErrorCodeAccess:                {"AccessError", "Access to the repository was denied; check the credentials."},
+}
+
+var misconfigurationCodes = map[int]bool{
+	ErrorCodeConfig:                true,
+	ErrorCodeOptionInvalid:         true,
+	ErrorCodeOptionInvalidValue:    true,
+	ErrorCodeOptionInvalidRange:    true,
+	ErrorCodeOptionInvalidPair:     true,
+	ErrorCodeOptionDuplicate:       true,
+	ErrorCodeOptionNegate:          true,
+	ErrorCodeOptionRequired:        true,
+	ErrorCodeOptionMultipleValue:   true,
+	ErrorCodeOptionCommand:         true,
+	ErrorCodeArchiveCommandInvalid: true,
+	ErrorCodeArchiveDisabled:       true,
+	ErrorCodeDbMismatch:            true,
+	ErrorCodeHostInvalid:           true,
+	ErrorCodeRepoInvalid:           true,
+}
+
+var errorCodePattern = regexp.MustCompile(`ERROR: \[(\d+)\]`)
+
+// Error describes a failing pgbackrest command.
+// Code is the pgbackrest error code, or 0 if the failure did not come from pgbackrest.
+type Error struct {
+	Message        string
+	Stdout, Stderr string
+	ExitCode       int
+	Code           int
+	Name           string
+	Hint           string
+}
+
+func (e *Error) Error() string {
+	if 0 == e.Code {
+		return fmt.Sprintf("Error: %s\nstderr: %s\nstdout: %s\n", e.Message, e.Stderr, e.Stdout)
+	}
+
+	return fmt.Sprintf("Error: %s [%03d %s] %s\nstderr: %s\nstdout: %s\n", e.Message, e.Code, e.Name, e.Hint, e.Stderr, e.Stdout)
+}
+
+// IsLockContention returns true if the command failed because another pgbackrest process holds the lock
+func (e *Error) IsLockContention() bool {
+	return ErrorCodeLockAcquire == e.Code
+}
+
+// IsMisconfiguration returns true if the command failed because of invalid options or configuration
+func (e *Error) IsMisconfiguration() bool {
+	return misconfigurationCodes[e.Code]
+}
+
+// newError creates an Error for a failed pgbackrest command from its exit status and
+// the "ERROR: [nnn]" line in its output
+func newError(stdout, stderr string, err error) *Error {
+	result := &Error{
+		Message: err.Error(),
+		Stdout:  stdout,
+		Stderr:  stderr,
+		Code:    parseErrorCode(stderr + "\n" + stdout),
+	}
+
+	if exitErr, ok := err.(interface{ ExitStatus() int }); ok {
+		result.ExitCode = exitErr.ExitStatus()
+	}
+
+	if 0 == result.Code {
+		if _, known := errorDescriptions[result.ExitCode]; known {
+			result.Code = result.ExitCode
+		}
+	}
+
+	if description, known := errorDescriptions[result.Code]; known {
+		result.Name = description.name
+		result.Hint = description.hint
+	}
+
+	return result
+}
+
+// fromPitrError converts an error that was not caused by pgbackrest
+func fromPitrError(err *pitr.Error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{
+		Message: err.Message,
+		Stdout:  err.Stdout,
+		Stderr:  err.Stderr,
+	}
+}
+
+// PitrError converts the error for callers that deal with *pitr.Error only
+func (e *Error) PitrError() *pitr.Error {
+	if e == nil {
+		return nil
+	}
+
+	message := e.Message
+
+	if 0 != e.Code {
+		message = fmt.Sprintf("%s [%03d %s]", e.Message, e.Code, e.Name)
+	}
+
+	return &pitr.Error{
+		Message: message,
+		Stdout:  e.Stdout,
+		Stderr:  e.Stderr,
+	}
+}
+
+func parseErrorCode(output string) int {
+	match := errorCodePattern.FindStringSubmatch(output)
+
+	if match == nil {
+		return 0
+	}
+
+	code, err := strconv.Atoi(match[1])
+
+	if err != nil {
+		return 0
+	}
+
+	return code
+}
@@ -0,0 +1,81 @@
+package pgbackrest_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Errors", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "")
+	})
+
+	JustBeforeEach(func() {
+		err = ctl.Check("pitr")
+	})
+
+	Context("another process holds the lock", func() {
+		BeforeEach(func() {
+			runner.On("check", "", "ERROR: [050]: unable to acquire lock on file '/tmp/pgbackrest/pitr-backup.lock'", h.ExitError{Status: 50})
+		})
+
+		It("parses the code", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeLockAcquire))
+			Expect(err.ExitCode).To(Equal(50))
+			Expect(err.Name).To(Equal("LockAcquireError"))
+			Expect(err.Hint).NotTo(BeEmpty())
+		})
+
+		It("is lock contention", func() {
+			Expect(err.IsLockContention()).To(BeTrue())
+			Expect(err.IsMisconfiguration()).To(BeFalse())
+		})
+
+		It("keeps the code when converted", func() {
+			Expect(err.PitrError().Message).To(ContainSubstring("[050 LockAcquireError]"))
+		})
+	})
+
+	Context("the configuration is invalid", func() {
+		BeforeEach(func() {
+			runner.On("check", "", "ERROR: [031]: option 'pg1-path' must be set", h.ExitError{Status: 31})
+		})
+
+		It("is a misconfiguration", func() {
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeOptionInvalid))
+			Expect(err.IsMisconfiguration()).To(BeTrue())
+			Expect(err.IsLockContention()).To(BeFalse())
+		})
+	})
+
+	Context("the output has no error line", func() {
+		BeforeEach(func() {
+			runner.On("check", "", "", h.ExitError{Status: 56})
+		})
+
+		It("falls back to the exit code", func() {
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeDbConnect))
+		})
+	})
+
+	Context("the command did not fail with an exit status", func() {
+		BeforeEach(func() {
+			runner.On("check", "", "", errors.New("connection lost"))
+		})
+
+		It("has no code", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(BeZero())
+			Expect(err.Name).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,193 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Retention types supported by pgbackrest
+const (
+	RetentionTypeCount = "count"
+	RetentionTypeTime  = "time"
+)
+
+// ExpireOptions control which backups and WAL segments are expired. The zero value applies the
+// retention configured in pgbackrest.conf.
+type ExpireOptions struct {
+	// RetentionFull is the number of full backups (or days, depending on RetentionFullType) to keep; 0 keeps the configured value
+	RetentionFull int
+
+	// RetentionFullType is one of RetentionTypeCount or RetentionTypeTime; empty keeps the configured value
+	RetentionFullType string
+
+	// RetentionDiff is the number of differential backups to keep; 0 keeps the configured value
+	RetentionDiff int
+
+	// RetentionArchive is the number of backups for which WAL is kept; 0 keeps the configured value
+	RetentionArchive int
+
+	// RetentionArchiveType is one of BackupTypeFull, BackupTypeDifferential or BackupTypeIncremental; empty keeps the configured value
+	RetentionArchiveType string
+
+	// Set expires the backup with the given label and all backups depending on it, regardless of retention
+	Set string
+
+	// DryRun reports what would be expired without removing anything
+	DryRun bool
+
+	// Repo is the key of the repository to expire; 0 expires all repositories. Overriding the retention
+	// of a stanza with several repositories requires choosing one of them.
+	Repo int
+}
+
+// ExpiredArchive is a range of WAL segments removed from the repository
+type ExpiredArchive struct {
+	ID    string
+	Start string
+	Stop  string
+}
+
+// ExpireReport lists what was (or, for a dry run, would be) removed from the repository
+type ExpireReport struct {
+	DryRun   bool
+	Backups  []string
+	Archives []ExpiredArchive
+}
+
+var (
+	expiredBackupPattern  = regexp.MustCompile(`expire (?:full|diff|incr|adhoc) backup(?: set)?:? (.+)$`)
+	expiredArchivePattern = regexp.MustCompile(`(?:remove archive, archiveId = (\S+), start = (\w+), stop = (\w+))|(?:(\S+) remove archive, start = (\w+), stop = (\w+))`)
+)
+
+// Expire removes backups and WAL segments from the repository of the given stanza and reports what was removed
+func (ctl Controller) Expire(stanza string, options ExpireOptions) (ExpireReport, *Error) {
+	args, err := options.args()
+
+	if err != nil {
+		return ExpireReport{}, err
+	}
+
+	if "" != options.Set || (0 == options.Repo && options.overridesRetention()) {
+		info, err := ctl.stanzaInfo(stanza)
+
+		if err != nil {
+			return ExpireReport{}, err
+		}
+
+		if "" != options.Set && info.Backup(options.Set) == nil {
+			return ExpireReport{}, &Error{
+				Message: fmt.Sprintf("Stanza %s has no backup %s", stanza, options.Set),
+				Code:    ErrorCodeBackupSetInvalid,
+			}
+		}
+
+		// pgbackrest would apply the overridden retention to the first repository only, but expire all of them
+		if 0 == options.Repo && options.overridesRetention() && len(info.Repos) > 1 {
+			return ExpireReport{}, &Error{
+				Message: fmt.Sprintf("Stanza %s has %d repositories; overriding the retention requires choosing one of them", stanza, len(info.Repos)),
+				Code:    ErrorCodeOptionInvalid,
+			}
+		}
+	}
+
+	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s --log-level-console=info expire%s", stanza, args)
+
+	if runErr != nil {
+		return ExpireReport{}, newError(stdout, stderr, runErr)
+	}
+
+	report := ParseExpireOutput(stdout)
+	report.DryRun = options.DryRun
+
+	return report, nil
+}
+
+// ParseExpireOutput collects the expired backups and archive ranges from the info-level log of `pgbackrest expire`
+func ParseExpireOutput(stdout string) ExpireReport {
+	report := ExpireReport{}
+
+	for _, line := range strings.Split(stdout, "\n") {
+		if match := expiredBackupPattern.FindStringSubmatch(line); match != nil {
+			for _, label := range strings.Split(match[1], ",") {
+				report.Backups = append(report.Backups, strings.TrimSpace(label))
+			}
+
+			continue
+		}
+
+		if match := expiredArchivePattern.FindStringSubmatch(line); match != nil {
+			if "" != match[1] {
+				report.Archives = append(report.Archives, ExpiredArchive{ID: match[1], Start: match[2], Stop: match[3]})
+			} else {
+				report.Archives = append(report.Archives, ExpiredArchive{ID: match[4], Start: match[5], Stop: match[6]})
+			}
+		}
+	}
+
+	return report
+}
+
+// overridesRetention tells whether any of the configured retention settings is overridden
+func (options ExpireOptions) overridesRetention() bool {
+	return options.RetentionFull > 0 || "" != options.RetentionFullType || options.RetentionDiff > 0 ||
+		options.RetentionArchive > 0 || "" != options.RetentionArchiveType
+}
+
+func (options ExpireOptions) args() (string, *Error) {
+	var args strings.Builder
+
+	repo := options.Repo
+
+	if 0 == repo {
+		repo = 1
+	}
+
+	if options.RetentionFull > 0 {
+		fmt.Fprintf(&args, " --repo%d-retention-full=%d", repo, options.RetentionFull)
+	}
+
+	switch options.RetentionFullType {
+	case "":
+	case RetentionTypeCount, RetentionTypeTime:
+		fmt.Fprintf(&args, " --repo%d-retention-full-type=%s", repo, options.RetentionFullType)
+	default:
+		return "", &Error{
+			Message: fmt.Sprintf("Unknown retention type '%s'", options.RetentionFullType),
+			Code:    ErrorCodeOptionInvalidValue,
+		}
+	}
+
+	if options.RetentionDiff > 0 {
+		fmt.Fprintf(&args, " --repo%d-retention-diff=%d", repo, options.RetentionDiff)
+	}
+
+	if options.RetentionArchive > 0 {
+		fmt.Fprintf(&args, " --repo%d-retention-archive=%d", repo, options.RetentionArchive)
+	}
+
+	switch options.RetentionArchiveType {
+	case "":
+	case BackupTypeFull, BackupTypeDifferential, BackupTypeIncremental:
+		fmt.Fprintf(&args, " --repo%d-retention-archive-type=%s", repo, options.RetentionArchiveType)
+	default:
+		return "", &Error{
+			Message: fmt.Sprintf("Unknown archive retention type '%s'", options.RetentionArchiveType),
+			Code:    ErrorCodeOptionInvalidValue,
+		}
+	}
+
+	if "" != options.Set {
+		args.WriteString(" --set=" + shellQuote(options.Set))
+	}
+
+	if options.Repo > 0 {
+		fmt.Fprintf(&args, " --repo=%d", options.Repo)
+	}
+
+	if options.DryRun {
+		args.WriteString(" --dry-run")
+	}
+
+	return args.String(), nil
+}
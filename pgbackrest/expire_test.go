@@ -0,0 +1,192 @@
+package pgbackrest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Expire", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var options pgbackrest.ExpireOptions
+	var report pgbackrest.ExpireReport
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "info-2.19.json")
+		options = pgbackrest.ExpireOptions{}
+	})
+
+	JustBeforeEach(func() {
+		report, err = ctl.Expire("pitr", options)
+	})
+
+	It("applies the configured retention", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --log-level-console=info expire"))
+	})
+
+	Context("retention is overridden", func() {
+		BeforeEach(func() {
+			options.RetentionFull = 7
+			options.RetentionFullType = pgbackrest.RetentionTypeTime
+			options.RetentionDiff = 3
+			options.RetentionArchive = 2
+			options.RetentionArchiveType = pgbackrest.BackupTypeDifferential
+		})
+
+		It("passes the overrides", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("expire")).To(ConsistOf(
+				"sudo --user postgres pgbackrest --stanza=pitr --log-level-console=info expire" +
+					" --repo1-retention-full=7 --repo1-retention-full-type=time" +
+					" --repo1-retention-diff=3" +
+					" --repo1-retention-archive=2 --repo1-retention-archive-type=diff"))
+		})
+	})
+
+	Context("the retention type is unknown", func() {
+		BeforeEach(func() {
+			options.RetentionFullType = "forever"
+		})
+
+		It("does not run pgbackrest", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeOptionInvalidValue))
+			Expect(runner.Commands).To(BeEmpty())
+		})
+	})
+
+	Context("dry run", func() {
+		BeforeEach(func() {
+			options.DryRun = true
+			options.RetentionFull = 1
+
+			runner.On("expire", `2020-02-25 10:30:00.132 P00   INFO: expire command begin 2.24: --dry-run --log-level-console=info --pg1-path=/var/lib/postgresql/11/main --repo1-path=/var/lib/pgbackrest --repo1-retention-full=1 --stanza=pitr
+2020-02-25 10:30:00.135 P00   WARN: [DRY-RUN] expire command running in dry-run mode, no changes will be made
+2020-02-25 10:30:00.137 P00   INFO: [DRY-RUN] expire full backup set: 20191126-101005F, 20191126-101005F_20191126-101520D
+2020-02-25 10:30:00.141 P00   INFO: [DRY-RUN] remove archive, archiveId = 11-1, start = 000000010000000000000001, stop = 000000010000000000000004
+2020-02-25 10:30:00.142 P00   INFO: expire command end: completed successfully (11ms)`, "", nil)
+		})
+
+		It("passes the option", func() {
+			Expect(runner.CommandsContaining("expire")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --log-level-console=info expire --repo1-retention-full=1 --dry-run"))
+		})
+
+		It("reports the backups that would be expired", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.DryRun).To(BeTrue())
+			Expect(report.Backups).To(Equal([]string{"20191126-101005F", "20191126-101005F_20191126-101520D"}))
+		})
+
+		It("reports the WAL that would be removed", func() {
+			Expect(report.Archives).To(Equal([]pgbackrest.ExpiredArchive{{
+				ID:    "11-1",
+				Start: "000000010000000000000001",
+				Stop:  "000000010000000000000004",
+			}}))
+		})
+	})
+
+	Context("expiring a specific set", func() {
+		BeforeEach(func() {
+			options.Set = "20191126-101005F_20191126-101520D"
+
+			runner.On("expire", `2021-04-06 09:12:44.211 P00   INFO: expire command begin 2.33: --exec-id=21373-4b2c9d1e --log-level-console=info --pg1-path=/var/lib/postgresql/11/main --repo1-path=/var/lib/pgbackrest --set=20191126-101005F_20191126-101520D --stanza=pitr
+2021-04-06 09:12:44.215 P00   WARN: repo1: expiring latest backup 20191126-101005F_20191126-102044I - the ability to perform point-in-time-recovery (PITR) may be affected
+            HINT: non-default settings for 'repo1-retention-archive'/'repo1-retention-archive-type' (even in prior expires) can cause gaps in the WAL.
+2021-04-06 09:12:44.216 P00   INFO: repo1: expire adhoc backup set 20191126-101005F_20191126-101520D, 20191126-101005F_20191126-102044I
+2021-04-06 09:12:44.219 P00   INFO: repo1: remove expired backup 20191126-101005F_20191126-102044I
+2021-04-06 09:12:44.220 P00   INFO: repo1: remove expired backup 20191126-101005F_20191126-101520D
+2021-04-06 09:12:44.224 P00   INFO: expire command end: completed successfully (14ms)`, "", nil)
+		})
+
+		It("passes the set", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("expire")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --log-level-console=info expire --set='20191126-101005F_20191126-101520D'"))
+		})
+
+		It("reports the set and the backups depending on it", func() {
+			Expect(report.Backups).To(Equal([]string{"20191126-101005F_20191126-101520D", "20191126-101005F_20191126-102044I"}))
+		})
+
+		Context("the set does not exist", func() {
+			BeforeEach(func() {
+				options.Set = "20191231-000000F"
+			})
+
+			It("does not run expire", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeBackupSetInvalid))
+				Expect(runner.CommandsContaining("expire")).To(BeEmpty())
+			})
+		})
+	})
+})
+
+var _ = Describe("Expiring a stanza with several repositories", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var options pgbackrest.ExpireOptions
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("13", "info-2.36-multi-repo.json")
+		options = pgbackrest.ExpireOptions{RetentionFull: 2}
+	})
+
+	JustBeforeEach(func() {
+		_, err = ctl.Expire("pitr", options)
+	})
+
+	It("does not override the retention of all repositories", func() {
+		Expect(err).To(HaveOccurred())
+		Expect(err.Code).To(Equal(pgbackrest.ErrorCodeOptionInvalid))
+		Expect(runner.CommandsContaining("expire")).To(BeEmpty())
+	})
+
+	Context("a repository is chosen", func() {
+		BeforeEach(func() {
+			options.Repo = 2
+		})
+
+		It("overrides the retention of that repository", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("expire")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --log-level-console=info expire --repo2-retention-full=2 --repo=2"))
+		})
+	})
+
+	Context("the configured retention applies", func() {
+		BeforeEach(func() {
+			options.RetentionFull = 0
+		})
+
+		It("expires all repositories", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --log-level-console=info expire"))
+		})
+	})
+})
+
+var _ = Describe("expire output parser", func() {
+	It("understands the output of pgbackrest with multiple repositories", func() {
+		report := pgbackrest.ParseExpireOutput(`P00   INFO: repo1: expire full backup set 20210328-120000F, 20210328-120000F_20210329-120000I
+P00   INFO: repo1: 13-1 remove archive, start = 000000010000000000000003, stop = 000000010000000000000007
+P00   INFO: repo1: 11-1 no archive to remove`)
+
+		Expect(report.Backups).To(Equal([]string{"20210328-120000F", "20210328-120000F_20210329-120000I"}))
+		Expect(report.Archives).To(Equal([]pgbackrest.ExpiredArchive{{
+			ID:    "13-1",
+			Start: "000000010000000000000003",
+			Stop:  "000000010000000000000007",
+		}}))
+	})
+
+	It("reports nothing if nothing was expired", func() {
+		report := pgbackrest.ParseExpireOutput("P00   INFO: expire command end: completed successfully")
+		Expect(report.Backups).To(BeEmpty())
+		Expect(report.Archives).To(BeEmpty())
+	})
+})
@@ -0,0 +1,229 @@
+package pgbackrest
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Info tells about the backups of a stanza, as reported by `pgbackrest info --output=json`
+type Info struct {
+	Name      string
+	Cipher    string
+	Status    InfoStatus
+	Databases []InfoDatabase `json:"db"`
+	Archives  []ArchiveRange `json:"archive"`
+	Backups   []Backup       `json:"backup"`
+	Repos     []InfoRepo     `json:"repo"`
+}
+
+// InfoStatus tells whether the stanza is healthy
+type InfoStatus struct {
+	Code    int
+	Message string
+	Lock    struct {
+		Backup struct {
+			Held bool
+		}
+	}
+}
+
+// InfoDatabase describes a PostgreSQL cluster that was backed up into the stanza
+type InfoDatabase struct {
+	ID       int
+	Version  string
+	SystemID uint64 `json:"system-id"`
+	RepoKey  int    `json:"repo-key"`
+}
+
+// InfoRepo describes the status of a repository holding the stanza
+type InfoRepo struct {
+	Key    int
+	Cipher string
+	Status struct {
+		Code    int
+		Message string
+	}
+}
+
+// DatabaseReference points to the PostgreSQL cluster (and repository) a backup or an archive belongs to
+type DatabaseReference struct {
+	ID      int
+	RepoKey int `json:"repo-key"`
+}
+
+// ArchiveRange describes the WAL segments archived for a PostgreSQL cluster
+type ArchiveRange struct {
+	ID       string
+	Database DatabaseReference
+	Min      string
+	Max      string
+}
+
+// Range has the first and the last item of something, e.g. WAL segments or LSNs
+type Range struct {
+	Start string
+	Stop  string
+}
+
+// BackupDatabase is a database contained in a backup
+type BackupDatabase struct {
+	Name string
+	OID  int
+}
+
+// Backup describes a single backup set
+type Backup struct {
+	Label     string
+	Type      string
+	Prior     string
+	Reference []string
+	Error     bool
+	Timestamp struct {
+		Start int64
+		Stop  int64
+	}
+	Archive   Range
+	LSN       Range
+	Database  DatabaseReference
+	Databases []BackupDatabase `json:"database-ref"`
+	Info      struct {
+		Size       int64
+		Delta      int64
+		Repository struct {
+			Size  int64
+			Delta int64
+		}
+	}
+	Backrest struct {
+		Format  int
+		Version string
+	}
+
+	// Annotations are the key/value pairs stored with the backup (pgbackrest 2.41 and later)
+	Annotations map[string]string `json:"annotation"`
+}
+
+// ParseInfo parses the JSON output of `pgbackrest info --output=json`
+func ParseInfo(stdout string) ([]Info, error) {
+	infos := make([]Info, 0)
+	err := json.Unmarshal([]byte(stdout), &infos)
+	return infos, err
+}
+
+// CurrentDatabase returns the PostgreSQL cluster the stanza was created or last upgraded for,
+// or nil if there is none
+func (info Info) CurrentDatabase() *InfoDatabase {
+	var current *InfoDatabase
+
+	for i, database := range info.Databases {
+		if current == nil || database.ID > current.ID {
+			current = &info.Databases[i]
+		}
+	}
+
+	return current
+}
+
+// BackupDatabase returns the PostgreSQL cluster the given backup was made of, or nil if the stanza does not know it.
+// After a stanza upgrade, older backups belong to the cluster of the previous version.
+func (info Info) BackupDatabase(backup Backup) *InfoDatabase {
+	for i, database := range info.Databases {
+		if database.ID == backup.Database.ID && (0 == database.RepoKey || database.RepoKey == backup.RepoKey()) {
+			return &info.Databases[i]
+		}
+	}
+
+	return nil
+}
+
+// LatestBackup returns the most recent backup, or nil if there is none
+func (info Info) LatestBackup() *Backup {
+	if 0 == len(info.Backups) {
+		return nil
+	}
+
+	return &info.Backups[len(info.Backups)-1]
+}
+
+// Backup returns the backup with the given label, or nil if there is none
+func (info Info) Backup(label string) *Backup {
+	for i, backup := range info.Backups {
+		if backup.Label == label {
+			return &info.Backups[i]
+		}
+	}
+
+	return nil
+}
+
+// LatestAnnotatedBackup returns the most recent backup carrying all of the given annotations,
+// or nil if there is none
+func (info Info) LatestAnnotatedBackup(annotations map[string]string) *Backup {
+	for i := len(info.Backups) - 1; i >= 0; i-- {
+		if info.Backups[i].HasAnnotations(annotations) {
+			return &info.Backups[i]
+		}
+	}
+
+	return nil
+}
+
+// HasAnnotations tells whether the backup carries all of the given annotations
+func (backup Backup) HasAnnotations(annotations map[string]string) bool {
+	for key, value := range annotations {
+		if actual, found := backup.Annotations[key]; !found || actual != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StartTime tells when the backup was started
+func (backup Backup) StartTime() time.Time {
+	return time.Unix(backup.Timestamp.Start, 0)
+}
+
+// StopTime tells when the backup was finished
+func (backup Backup) StopTime() time.Time {
+	return time.Unix(backup.Timestamp.Stop, 0)
+}
+
+// Repo returns the repository with the given key, or nil if there is none
+func (info Info) Repo(key int) *InfoRepo {
+	for i, repo := range info.Repos {
+		if repo.Key == key {
+			return &info.Repos[i]
+		}
+	}
+
+	return nil
+}
+
+// BackupsInRepo returns the backups stored in the repository with the given key.
+// Backups made before pgbackrest supported multiple repositories are in repository 1.
+func (info Info) BackupsInRepo(key int) []Backup {
+	backups := make([]Backup, 0)
+
+	for _, backup := range info.Backups {
+		if backup.RepoKey() == key {
+			backups = append(backups, backup)
+		}
+	}
+
+	return backups
+}
+
+// RepoKey returns the key of the repository the backup is stored in
+func (backup Backup) RepoKey() int {
+	if 0 == backup.Database.RepoKey {
+		return 1
+	}
+
+	return backup.Database.RepoKey
+}
+
+// OK tells whether the repository is healthy
+func (repo InfoRepo) OK() bool {
+	return 0 == repo.Status.Code
+}
@@ -0,0 +1,203 @@
+package pgbackrest_test
+
+import (
+	"io/ioutil"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("info output parser", func() {
+	var fixture string
+	var info pgbackrest.Info
+
+	BeforeEach(func() {
+		fixture = ""
+	})
+
+	JustBeforeEach(func() {
+		if "" == fixture {
+			return
+		}
+
+		stdout, err := ioutil.ReadFile(fixture)
+		Expect(err).NotTo(HaveOccurred())
+
+		infos, err := pgbackrest.ParseInfo(string(stdout))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(infos).To(HaveLen(1))
+		info = infos[0]
+	})
+
+	Context("pgbackrest 2.08", func() {
+		BeforeEach(func() {
+			fixture = "fixtures/info-2.08.json"
+		})
+
+		It("has the stanza", func() {
+			Expect(info.Name).To(Equal("pitr"))
+			Expect(info.Cipher).To(Equal("aes-256-cbc"))
+			Expect(info.Status.Code).To(Equal(0))
+			Expect(info.Status.Message).To(Equal("ok"))
+		})
+
+		It("has the database", func() {
+			Expect(info.Databases).To(HaveLen(1))
+			Expect(info.CurrentDatabase().Version).To(Equal("11"))
+			Expect(info.CurrentDatabase().SystemID).To(Equal(uint64(6648024843546425402)))
+		})
+
+		It("has the archive range", func() {
+			Expect(info.Archives).To(Equal([]pgbackrest.ArchiveRange{{
+				ID:       "11-1",
+				Database: pgbackrest.DatabaseReference{ID: 1},
+				Min:      "000000010000000000000001",
+				Max:      "000000010000000000000008",
+			}}))
+		})
+
+		Context("the full backup", func() {
+			var backup pgbackrest.Backup
+
+			JustBeforeEach(func() {
+				backup = info.Backups[0]
+			})
+
+			It("has the label and type", func() {
+				Expect(backup.Label).To(Equal("20190121-093614F"))
+				Expect(backup.Type).To(Equal("full"))
+			})
+
+			It("has no prior backup", func() {
+				Expect(backup.Prior).To(BeEmpty())
+				Expect(backup.Reference).To(BeEmpty())
+			})
+
+			It("has the timestamps", func() {
+				Expect(backup.StartTime()).To(BeTemporally("==", time.Date(2019, 1, 21, 9, 36, 14, 0, time.UTC)))
+				Expect(backup.StopTime()).To(BeTemporally("==", time.Date(2019, 1, 21, 9, 36, 20, 0, time.UTC)))
+			})
+
+			It("has the WAL range", func() {
+				Expect(backup.Archive.Start).To(Equal("000000010000000000000003"))
+				Expect(backup.Archive.Stop).To(Equal("000000010000000000000003"))
+			})
+
+			It("has the sizes", func() {
+				Expect(backup.Info.Size).To(Equal(int64(24127163)))
+				Expect(backup.Info.Delta).To(Equal(int64(24127163)))
+				Expect(backup.Info.Repository.Size).To(Equal(int64(2906346)))
+				Expect(backup.Info.Repository.Delta).To(Equal(int64(2906346)))
+			})
+
+			It("has the pgbackrest version", func() {
+				Expect(backup.Backrest.Version).To(Equal("2.08"))
+				Expect(backup.Backrest.Format).To(Equal(5))
+			})
+
+			It("has no LSN range, which this version does not provide", func() {
+				Expect(backup.LSN.Start).To(BeEmpty())
+			})
+		})
+
+		It("has the incremental backup on top of the full one", func() {
+			backup := info.LatestBackup()
+			Expect(backup.Type).To(Equal("incr"))
+			Expect(backup.Prior).To(Equal("20190121-093614F"))
+			Expect(backup.Reference).To(ConsistOf("20190121-093614F"))
+		})
+	})
+
+	Context("pgbackrest 2.19", func() {
+		BeforeEach(func() {
+			fixture = "fixtures/info-2.19.json"
+		})
+
+		It("has all backups", func() {
+			Expect(info.Backups).To(HaveLen(3))
+		})
+
+		It("finds a backup by label", func() {
+			backup := info.Backup("20191126-101005F_20191126-101520D")
+			Expect(backup).NotTo(BeNil())
+			Expect(backup.Type).To(Equal("diff"))
+		})
+
+		It("does not find an unknown label", func() {
+			Expect(info.Backup("20191126-000000F")).To(BeNil())
+		})
+
+		It("references all backups the latest one depends on", func() {
+			Expect(info.LatestBackup().Reference).To(HaveLen(2))
+		})
+	})
+
+	Context("pgbackrest 2.33", func() {
+		BeforeEach(func() {
+			fixture = "fixtures/info-2.33.json"
+		})
+
+		It("has the current database after a major upgrade", func() {
+			Expect(info.Databases).To(HaveLen(2))
+			Expect(info.CurrentDatabase().ID).To(Equal(2))
+			Expect(info.CurrentDatabase().Version).To(Equal("13"))
+			Expect(info.CurrentDatabase().RepoKey).To(Equal(1))
+		})
+
+		It("has an archive range per database", func() {
+			Expect(info.Archives).To(HaveLen(2))
+			Expect(info.Archives[1].ID).To(Equal("13-2"))
+			Expect(info.Archives[1].Database).To(Equal(pgbackrest.DatabaseReference{ID: 2, RepoKey: 1}))
+		})
+
+		It("has the LSN range", func() {
+			Expect(info.LatestBackup().LSN).To(Equal(pgbackrest.Range{Start: "0/5000028", Stop: "0/5000138"}))
+		})
+
+		It("has the databases contained in a backup", func() {
+			Expect(info.LatestBackup().Databases).To(ConsistOf(
+				pgbackrest.BackupDatabase{Name: "postgres", OID: 13395},
+				pgbackrest.BackupDatabase{Name: "sandbox", OID: 16384},
+			))
+		})
+
+		It("has the repository status", func() {
+			Expect(info.Repos).To(HaveLen(1))
+			Expect(info.Repos[0].Key).To(Equal(1))
+			Expect(info.Repos[0].Status.Message).To(Equal("ok"))
+		})
+
+		It("knows whether a backup is running", func() {
+			Expect(info.Status.Lock.Backup.Held).To(BeFalse())
+		})
+	})
+
+	Context("pgbackrest 2.41 with annotations", func() {
+		BeforeEach(func() {
+			fixture = "fixtures/info-2.41-annotations.json"
+		})
+
+		It("has the annotations of each backup", func() {
+			Expect(info.Backups[0].Annotations).To(Equal(map[string]string{"ticket": "OPS-41"}))
+			Expect(info.Backups[1].Annotations).To(HaveKeyWithValue("marker", "pre-migration"))
+		})
+
+		It("finds the latest backup carrying all given annotations", func() {
+			Expect(info.LatestAnnotatedBackup(map[string]string{"ticket": "OPS-42"}).Label).To(Equal("20191126-101005F_20191126-102044I"))
+			Expect(info.LatestAnnotatedBackup(map[string]string{"ticket": "OPS-42", "release": "1.2.0"}).Label).To(Equal("20191126-101005F_20191126-101520D"))
+		})
+
+		It("finds nothing if no backup carries all annotations", func() {
+			Expect(info.LatestAnnotatedBackup(map[string]string{"ticket": "OPS-41", "release": "1.2.0"})).To(BeNil())
+		})
+	})
+
+	Context("invalid output", func() {
+		It("is rejected", func() {
+			_, err := pgbackrest.ParseInfo("ERROR: [055]: unable to load info file")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,152 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultLockPath is where pgbackrest keeps its lock files unless lock-path is set
+const defaultLockPath = "/tmp/pgbackrest"
+
+// archiveCommand matches the command line of archive-push and archive-get, including their async workers
+var archiveCommand = regexp.MustCompile(`\sarchive-(push|get)(:async)?(\s|$)`)
+
+// LockStatus tells which pgbackrest operations are running for a stanza
+type LockStatus struct {
+	// BackupHeld is true if a backup (or expire) holds the backup lock, according to pgbackrest info
+	BackupHeld bool
+
+	// Files are the lock files of backup, expire and stanza commands, like pitr-backup.lock
+	Files []string
+
+	// Processes are the pgbackrest processes other than archiving that work on the stanza, with their command line
+	Processes []string
+
+	// ArchiveFiles are the lock files of archive-push and archive-get, like pitr-archive.lock
+	ArchiveFiles []string
+
+	// ArchiveProcesses are the archive-push and archive-get processes of the stanza, with their command line
+	ArchiveProcesses []string
+
+	// Stopped is true if `pgbackrest stop` prevents new operations on the stanza
+	Stopped bool
+}
+
+// Locks detects running pgbackrest operations for the given stanza
+func (ctl Controller) Locks(stanza string) (LockStatus, *Error) {
+	status := LockStatus{}
+
+	info, err := ctl.stanzaInfo(stanza)
+
+	if err != nil {
+		return status, err
+	}
+
+	status.BackupHeld = info.Status.Lock.Backup.Held
+
+	conf, err := ctl.DeployedConf()
+
+	if err != nil {
+		return status, err
+	}
+
+	lockPath := confValue(conf, stanza, "backup", "lock-path")
+
+	if "" == lockPath {
+		lockPath = defaultLockPath
+	}
+
+	files, err := ctl.listFiles(lockPath)
+
+	if err != nil {
+		return status, err
+	}
+
+	for _, file := range files {
+		switch {
+		case strings.HasPrefix(file, stanza+"-archive") && strings.HasSuffix(file, ".lock"):
+			status.ArchiveFiles = append(status.ArchiveFiles, file)
+		case strings.HasPrefix(file, stanza+"-") && strings.HasSuffix(file, ".lock"):
+			status.Files = append(status.Files, file)
+		case stanza+".stop" == file || "all.stop" == file:
+			status.Stopped = true
+		}
+	}
+
+	// the brackets keep the pattern from matching the shell running pgrep
+	stdout, stderr, runErr := ctl.runner.Run("pgrep --list-full --full '[p]gbackrest.*--stanza=%s( |$)'", stanza)
+
+	if runErr != nil {
+		if err = newError(stdout, stderr, runErr); 1 != err.ExitCode { // 1 means no process matched
+			return status, err
+		}
+	}
+
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case "" == line:
+		case archiveCommand.MatchString(line):
+			status.ArchiveProcesses = append(status.ArchiveProcesses, line)
+		default:
+			status.Processes = append(status.Processes, line)
+		}
+	}
+
+	return status, nil
+}
+
+// Locked returns true if a backup, expire or stanza command is running for the stanza. Archiving is
+// ignored, as PostgreSQL runs archive-push (or archive-get) all the time; see Archiving.
+func (status LockStatus) Locked() bool {
+	return status.BackupHeld || 0 < len(status.Files) || 0 < len(status.Processes)
+}
+
+// Archiving returns true if archive-push or archive-get is running for the stanza
+func (status LockStatus) Archiving() bool {
+	return 0 < len(status.ArchiveFiles) || 0 < len(status.ArchiveProcesses)
+}
+
+// Stop prevents new pgbackrest operations on the given stanza, e.g. for a maintenance window.
+// With force, running operations are terminated, too.
+func (ctl Controller) Stop(stanza string, force bool) *Error {
+	if force {
+		return ctl.runStanzaCommand(stanza, "stop --force")
+	}
+
+	return ctl.runStanzaCommand(stanza, "stop")
+}
+
+// Start allows pgbackrest operations on the given stanza again after Stop
+func (ctl Controller) Start(stanza string) *Error {
+	return ctl.runStanzaCommand(stanza, "start")
+}
+
+// backupWhenUnlocked runs the backup, retrying while another pgbackrest process holds the lock of the stanza
+func (ctl Controller) backupWhenUnlocked(stanza, args string, timeout time.Duration) (string, string, *Error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s backup%s", stanza, args)
+
+		if runErr == nil {
+			return stdout, stderr, nil
+		}
+
+		err := newError(stdout, stderr, runErr)
+
+		if !err.IsLockContention() || 0 == timeout {
+			return stdout, stderr, err
+		}
+
+		if time.Now().After(deadline) {
+			err.Message = fmt.Sprintf("Another pgbackrest operation still holds the lock of stanza %s after %s: %s", stanza, timeout, err.Message)
+			return stdout, stderr, err
+		}
+
+		time.Sleep(ctl.PollInterval)
+	}
+}
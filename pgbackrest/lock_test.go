@@ -0,0 +1,134 @@
+package pgbackrest_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Locks", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var info string
+	var status pgbackrest.LockStatus
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		info = readFixture("info-2.36-multi-repo.json")
+
+		runner, ctl = fakeController("13", "info-2.36-multi-repo.json")
+		runner.On("find /tmp/pgbackrest", "", "find: '/tmp/pgbackrest': No such file or directory", h.ExitError{Status: 1})
+		runner.On("pgrep", "", "", h.ExitError{Status: 1})
+	})
+
+	JustBeforeEach(func() {
+		status, err = ctl.Locks("pitr")
+	})
+
+	Context("nothing is running", func() {
+		It("is not locked", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Locked()).To(BeFalse())
+			Expect(status.Stopped).To(BeFalse())
+		})
+
+		It("looks for processes of the stanza only", func() {
+			Expect(runner.CommandsContaining("pgrep")).To(ConsistOf("pgrep --list-full --full '[p]gbackrest.*--stanza=pitr( |$)'"))
+		})
+	})
+
+	Context("a backup is running", func() {
+		BeforeEach(func() {
+			runner.On("pgbackrest info", strings.Replace(info, `"held": false`, `"held": true`, 1), "", nil)
+			runner.On("find /tmp/pgbackrest", "pitr-backup.lock\nother-backup.lock\n", "", nil)
+			runner.On("pgrep", "4711 pgbackrest --stanza=pitr backup --type=full\n", "", nil)
+		})
+
+		It("is locked", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Locked()).To(BeTrue())
+			Expect(status.BackupHeld).To(BeTrue())
+			Expect(status.Files).To(ConsistOf("pitr-backup.lock"))
+			Expect(status.Processes).To(ConsistOf("4711 pgbackrest --stanza=pitr backup --type=full"))
+		})
+	})
+
+	Context("WAL is being archived", func() {
+		BeforeEach(func() {
+			runner.On("find /tmp/pgbackrest", "pitr-archive.lock\n", "", nil)
+			runner.On("pgrep", "4712 pgbackrest --stanza=pitr archive-push pg_wal/000000010000000000000042\n4713 pgbackrest --stanza=pitr archive-push:async /var/lib/postgresql/13/main/pg_wal\n", "", nil)
+		})
+
+		It("is not locked", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Locked()).To(BeFalse())
+			Expect(status.Files).To(BeEmpty())
+			Expect(status.Processes).To(BeEmpty())
+		})
+
+		It("reports the archiving separately", func() {
+			Expect(status.Archiving()).To(BeTrue())
+			Expect(status.ArchiveFiles).To(ConsistOf("pitr-archive.lock"))
+			Expect(status.ArchiveProcesses).To(HaveLen(2))
+		})
+	})
+
+	Context("the stanza was stopped", func() {
+		BeforeEach(func() {
+			runner.On("find /tmp/pgbackrest", "pitr.stop\n", "", nil)
+		})
+
+		It("knows", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Stopped).To(BeTrue())
+			Expect(status.Locked()).To(BeFalse())
+		})
+	})
+
+	Context("the lock path is configured", func() {
+		BeforeEach(func() {
+			runner.On("cat /etc/pgbackrest.conf", deployedConf+"\n[global]\nlock-path=/run/pgbackrest\n", "", nil)
+		})
+
+		It("looks there", func() {
+			Expect(runner.CommandsContaining("find /run/pgbackrest")).To(HaveLen(1))
+		})
+	})
+
+	Context("pgrep fails", func() {
+		BeforeEach(func() {
+			runner.On("pgrep", "", "pgrep: invalid option", h.ExitError{Status: 2})
+		})
+
+		It("fails", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("Maintenance window", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("13", "")
+	})
+
+	It("stops pgbackrest", func() {
+		Expect(ctl.Stop("pitr", false)).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr stop"))
+	})
+
+	It("terminates running operations when forced", func() {
+		Expect(ctl.Stop("pitr", true)).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr stop --force"))
+	})
+
+	It("starts pgbackrest", func() {
+		Expect(ctl.Start("pitr")).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr start"))
+	})
+})
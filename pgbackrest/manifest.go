@@ -0,0 +1,301 @@
+package pgbackrest
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Manifest describes the contents of a backup set, as recorded in its backup.manifest
+type Manifest struct {
+	Label        string
+	Type         string
+	Prior        string
+	ArchiveStart string
+	ArchiveStop  string
+	LSNStart     string
+	LSNStop      string
+	Timestamp    struct {
+		Start int64
+		Stop  int64
+	}
+
+	Cluster   ManifestCluster
+	Targets   []ManifestTarget
+	Databases []ManifestDatabase
+	Files     []ManifestFile
+	Paths     []ManifestPath
+	Links     []ManifestLink
+
+	// BackrestVersion is the version of pgbackrest that made the backup
+	BackrestVersion string
+}
+
+// ManifestCluster describes the PostgreSQL cluster that was backed up
+type ManifestCluster struct {
+	ID             int
+	SystemID       uint64
+	Version        string
+	CatalogVersion int
+	ControlVersion int
+}
+
+// ManifestTarget is a location the backup copies files from: the data directory, a tablespace,
+// or a file or directory linked from the data directory
+type ManifestTarget struct {
+	Name           string
+	Type           string
+	Path           string
+	File           string
+	TablespaceID   string `json:"tablespace-id"`
+	TablespaceName string `json:"tablespace-name"`
+}
+
+// ManifestDatabase is a database contained in the backup
+type ManifestDatabase struct {
+	Name         string
+	OID          int `json:"db-id"`
+	LastSystemID int `json:"db-last-system-id"`
+}
+
+// ManifestFile is a file contained in the backup. Reference is the label of the prior backup
+// holding the file, if it did not change. ChecksumPage is nil unless page checksums were verified.
+type ManifestFile struct {
+	Name         string
+	Size         int64
+	RepoSize     int64 `json:"repo-size"`
+	Checksum     string
+	ChecksumPage *bool `json:"checksum-page"`
+	Reference    string
+	Timestamp    int64
+	Mode         string
+	User         string
+	Group        string
+}
+
+// ManifestPath is a directory contained in the backup
+type ManifestPath struct {
+	Name  string
+	Mode  string
+	User  string
+	Group string
+}
+
+// ManifestLink is a symbolic link contained in the backup
+type ManifestLink struct {
+	Name        string
+	Destination string
+	User        string
+	Group       string
+}
+
+// Manifest reads the manifest of the given backup set from the repository holding it
+func (ctl Controller) Manifest(stanza, set string) (*Manifest, *Error) {
+	info, err := ctl.stanzaInfo(stanza)
+
+	if err != nil {
+		return nil, err
+	}
+
+	backup := info.Backup(set)
+
+	if backup == nil {
+		return nil, &Error{
+			Message: fmt.Sprintf("Stanza %s has no backup %s", stanza, set),
+			Code:    ErrorCodeBackupSetInvalid,
+		}
+	}
+
+	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s --repo=%d repo-get %s", stanza, backup.RepoKey(), shellQuote(fmt.Sprintf("backup/%s/%s/backup.manifest", stanza, set)))
+
+	if runErr != nil {
+		return nil, newError(stdout, stderr, runErr)
+	}
+
+	manifest, parseErr := ParseManifest(stdout)
+
+	if parseErr != nil {
+		return nil, &Error{
+			Message: parseErr.Error(),
+			Stdout:  stdout,
+			Stderr:  stderr,
+			Code:    ErrorCodeFormat,
+		}
+	}
+
+	return manifest, nil
+}
+
+// ParseManifest parses a backup.manifest. Each value is JSON, within the INI format of pgbackrest.conf.
+func ParseManifest(content string) (*Manifest, error) {
+	conf, err := ParseConf(content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	p := manifestParser{conf: conf}
+
+	p.value("backrest", "backrest-version", &m.BackrestVersion)
+	p.value("backup", "backup-label", &m.Label)
+	p.value("backup", "backup-type", &m.Type)
+	p.value("backup", "backup-prior", &m.Prior)
+	p.value("backup", "backup-archive-start", &m.ArchiveStart)
+	p.value("backup", "backup-archive-stop", &m.ArchiveStop)
+	p.value("backup", "backup-lsn-start", &m.LSNStart)
+	p.value("backup", "backup-lsn-stop", &m.LSNStop)
+	p.value("backup", "backup-timestamp-start", &m.Timestamp.Start)
+	p.value("backup", "backup-timestamp-stop", &m.Timestamp.Stop)
+
+	p.value("backup:db", "db-id", &m.Cluster.ID)
+	p.value("backup:db", "db-system-id", &m.Cluster.SystemID)
+	p.value("backup:db", "db-version", &m.Cluster.Version)
+	p.value("backup:db", "db-catalog-version", &m.Cluster.CatalogVersion)
+	p.value("backup:db", "db-control-version", &m.Cluster.ControlVersion)
+
+	for _, name := range p.keys("backup:target") {
+		target := ManifestTarget{Name: name}
+		p.value("backup:target", name, &target)
+		m.Targets = append(m.Targets, target)
+	}
+
+	for _, name := range p.keys("db") {
+		database := ManifestDatabase{Name: name}
+		p.value("db", name, &database)
+		m.Databases = append(m.Databases, database)
+	}
+
+	for _, name := range p.keys("target:file") {
+		file := ManifestFile{Name: name}
+		p.value("target:file:default", "mode", &file.Mode)
+		p.value("target:file:default", "user", &file.User)
+		p.value("target:file:default", "group", &file.Group)
+		p.value("target:file", name, &file)
+		m.Files = append(m.Files, file)
+	}
+
+	for _, name := range p.keys("target:path") {
+		path := ManifestPath{Name: name}
+		p.value("target:path:default", "mode", &path.Mode)
+		p.value("target:path:default", "user", &path.User)
+		p.value("target:path:default", "group", &path.Group)
+		p.value("target:path", name, &path)
+		m.Paths = append(m.Paths, path)
+	}
+
+	for _, name := range p.keys("target:link") {
+		link := ManifestLink{Name: name}
+		p.value("target:link:default", "user", &link.User)
+		p.value("target:link:default", "group", &link.Group)
+		p.value("target:link", name, &link)
+		m.Links = append(m.Links, link)
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if "" == m.Label {
+		return nil, fmt.Errorf("Error parsing manifest; it has no backup label")
+	}
+
+	return m, nil
+}
+
+// Size returns the size of all files in the backup, before compression
+func (m Manifest) Size() int64 {
+	var size int64
+
+	for _, file := range m.Files {
+		size += file.Size
+	}
+
+	return size
+}
+
+// RepoSize returns the size of all files in the repository, including those referenced from prior backups
+func (m Manifest) RepoSize() int64 {
+	var size int64
+
+	for _, file := range m.Files {
+		size += file.RepoSize
+	}
+
+	return size
+}
+
+// Tablespaces returns the targets that are tablespaces
+func (m Manifest) Tablespaces() []ManifestTarget {
+	tablespaces := make([]ManifestTarget, 0)
+
+	for _, target := range m.Targets {
+		if "" != target.TablespaceID {
+			tablespaces = append(tablespaces, target)
+		}
+	}
+
+	return tablespaces
+}
+
+// Database returns the database with the given name, or nil if the backup does not contain it
+func (m Manifest) Database(name string) *ManifestDatabase {
+	for i, database := range m.Databases {
+		if database.Name == name {
+			return &m.Databases[i]
+		}
+	}
+
+	return nil
+}
+
+// FilesOfDatabase returns the files of the database with the given OID, in the default tablespace or any other
+func (m Manifest) FilesOfDatabase(oid int) []ManifestFile {
+	files := make([]ManifestFile, 0)
+	pattern := regexp.MustCompile(fmt.Sprintf(`^(pg_data/base|pg_tblspc/\d+/[^/]+)/%d/`, oid))
+
+	for _, file := range m.Files {
+		if pattern.MatchString(file.Name) {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// manifestParser decodes the JSON values of a manifest, remembering the first error
+type manifestParser struct {
+	conf Conf
+	err  error
+}
+
+func (p *manifestParser) value(section, key string, target interface{}) {
+	raw, found := p.conf.Get(section, key)
+
+	if !found || p.err != nil {
+		return
+	}
+
+	if err := json.Unmarshal([]byte(raw), target); err != nil {
+		p.err = fmt.Errorf("Error parsing %s in section [%s] of manifest: %s", key, section, err)
+	}
+}
+
+// keys returns the keys of a section in sorted order
+func (p *manifestParser) keys(section string) []string {
+	keys := make([]string, 0)
+	s := p.conf.section(section, false)
+
+	if s == nil {
+		return keys
+	}
+
+	for _, option := range s.Options {
+		keys = append(keys, option.Key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,146 @@
+package pgbackrest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Manifest", func() {
+	var fixture string
+
+	BeforeEach(func() {
+		fixture = readFixture("backup.manifest")
+	})
+
+	Context("parsing", func() {
+		var manifest *pgbackrest.Manifest
+
+		BeforeEach(func() {
+			var err error
+			manifest, err = pgbackrest.ParseManifest(fixture)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("has the backup", func() {
+			Expect(manifest.Label).To(Equal("20211001-100000F"))
+			Expect(manifest.Type).To(Equal("full"))
+			Expect(manifest.Prior).To(BeEmpty())
+			Expect(manifest.LSNStop).To(Equal("0/3000100"))
+			Expect(manifest.Timestamp.Stop).To(Equal(int64(1633082405)))
+			Expect(manifest.BackrestVersion).To(Equal("2.36"))
+		})
+
+		It("has the cluster", func() {
+			Expect(manifest.Cluster).To(Equal(pgbackrest.ManifestCluster{
+				ID:             1,
+				SystemID:       7013980736471924783,
+				Version:        "13",
+				CatalogVersion: 202007201,
+				ControlVersion: 1300,
+			}))
+		})
+
+		It("has the databases with their OIDs", func() {
+			Expect(manifest.Databases).To(HaveLen(5))
+			Expect(manifest.Database("sandbox")).To(Equal(&pgbackrest.ManifestDatabase{Name: "sandbox", OID: 16384, LastSystemID: 13394}))
+			Expect(manifest.Database("missing")).To(BeNil())
+		})
+
+		It("has the tablespaces", func() {
+			Expect(manifest.Targets).To(HaveLen(3))
+			Expect(manifest.Tablespaces()).To(ConsistOf(pgbackrest.ManifestTarget{
+				Name:           "pg_tblspc/16385",
+				Type:           "link",
+				Path:           "/mnt/tablespaces/archive",
+				TablespaceID:   "16385",
+				TablespaceName: "archive",
+			}))
+		})
+
+		It("has the files with sizes and checksums", func() {
+			Expect(manifest.Files).To(HaveLen(7))
+			Expect(manifest.Files[0]).To(Equal(pgbackrest.ManifestFile{
+				Name:      "pg_data/PG_VERSION",
+				Size:      3,
+				RepoSize:  23,
+				Checksum:  "d3a7d5c2d6e0c4b5a1f9a2e3b4c5d6e7f8a9b0c1",
+				Timestamp: 1633082300,
+				Mode:      "0600",
+				User:      "postgres",
+				Group:     "postgres",
+			}))
+			Expect(manifest.Size()).To(Equal(int64(3 + 226 + 16384 + 8192 + 8192 + 88 + 65536)))
+			Expect(manifest.RepoSize()).To(Equal(int64(23 + 167 + 4125 + 2060 + 325 + 108 + 1024)))
+		})
+
+		It("overrides the defaults of a file", func() {
+			file := manifest.Files[5]
+			Expect(file.Name).To(Equal("pg_data/postgresql.auto.conf"))
+			Expect(file.Mode).To(Equal("0644"))
+			Expect(file.User).To(Equal("root"))
+			Expect(file.Group).To(Equal("postgres"))
+		})
+
+		It("knows which page checksums were valid", func() {
+			Expect(*manifest.Files[2].ChecksumPage).To(BeTrue())
+			Expect(*manifest.Files[3].ChecksumPage).To(BeFalse())
+			Expect(manifest.Files[0].ChecksumPage).To(BeNil())
+		})
+
+		It("finds the files of a database in all tablespaces", func() {
+			Expect(manifest.FilesOfDatabase(16384)).To(HaveLen(2))
+			Expect(manifest.FilesOfDatabase(16386)).To(HaveLen(1))
+			Expect(manifest.FilesOfDatabase(16386)[0].Name).To(Equal("pg_tblspc/16385/PG_13_202007201/16386/16387"))
+			Expect(manifest.FilesOfDatabase(16385)).To(BeEmpty())
+		})
+
+		It("has the paths and links", func() {
+			Expect(manifest.Paths).To(HaveLen(8))
+			Expect(manifest.Paths[0]).To(Equal(pgbackrest.ManifestPath{Name: "pg_data", Mode: "0700", User: "postgres", Group: "postgres"}))
+			Expect(manifest.Links).To(ContainElement(pgbackrest.ManifestLink{
+				Name:        "pg_data/pg_tblspc/16385",
+				Destination: "/mnt/tablespaces/archive",
+				User:        "postgres",
+				Group:       "postgres",
+			}))
+		})
+	})
+
+	Context("invalid content", func() {
+		It("rejects a value that is not JSON", func() {
+			_, err := pgbackrest.ParseManifest("[backup]\nbackup-label=20211001-100000F\n")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects a manifest without label", func() {
+			_, err := pgbackrest.ParseManifest("[backup:db]\ndb-id=1\n")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("reading from the repository", func() {
+		var runner *h.FakeRunner
+		var ctl pgbackrest.Controller
+
+		BeforeEach(func() {
+			runner, ctl = fakeController("13", "info-2.36-multi-repo.json")
+			runner.On("repo-get", fixture, "", nil)
+		})
+
+		It("gets the manifest from the repository holding the backup", func() {
+			manifest, err := ctl.Manifest("pitr", "20211001-110000F")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Label).To(Equal("20211001-100000F"))
+			Expect(runner.CommandsContaining("repo-get")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --repo=2 repo-get 'backup/pitr/20211001-110000F/backup.manifest'"))
+		})
+
+		It("rejects an unknown backup", func() {
+			_, err := ctl.Manifest("pitr", "20211001-000000F")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeBackupSetInvalid))
+			Expect(runner.CommandsContaining("repo-get")).To(BeEmpty())
+		})
+	})
+})
@@ -1,10 +1,16 @@
 package pgbackrest_test
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"math/rand"
+	"path/filepath"
 	"testing"
 
 	"github.com/mikkeloscar/sshconfig"
+	"github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
 	"github.com/suhlig/postgres-pitr/vagrant"
 
 	. "github.com/onsi/ginkgo"
@@ -30,3 +36,48 @@ func TestPostgresPitr(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "PgBackRest Suite")
 }
+
+// readFixture returns the content of the named file in the fixtures directory
+func readFixture(name string) string {
+	content, err := ioutil.ReadFile(filepath.Join("fixtures", name))
+	Expect(err).NotTo(HaveOccurred())
+
+	return string(content)
+}
+
+// fakeController returns a controller of the main cluster in the given version that runs its commands
+// with the returned fake runner. Unless infoFixture is empty, the runner answers pgbackrest info with it.
+func fakeController(version, infoFixture string) (*h.FakeRunner, pgbackrest.Controller) {
+	runner := &h.FakeRunner{}
+
+	if infoFixture != "" {
+		runner.On("pgbackrest info", readFixture(infoFixture), "", nil)
+	}
+
+	return runner, pgbackrest.NewController(runner, cluster.NewController(runner, version, "main"))
+}
+
+// withoutBackup removes the backup with the given label from the output of pgbackrest info,
+// e.g. to show the state before that backup was made
+func withoutBackup(info, label string) string {
+	var stanzas []map[string]interface{}
+	Expect(json.Unmarshal([]byte(info), &stanzas)).To(Succeed())
+
+	for _, stanza := range stanzas {
+		backups, _ := stanza["backup"].([]interface{})
+		kept := make([]interface{}, 0, len(backups))
+
+		for _, backup := range backups {
+			if backup.(map[string]interface{})["label"] != label {
+				kept = append(kept, backup)
+			}
+		}
+
+		stanza["backup"] = kept
+	}
+
+	result, err := json.Marshal(stanzas)
+	Expect(err).NotTo(HaveOccurred())
+
+	return string(result)
+}
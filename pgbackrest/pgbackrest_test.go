@@ -36,7 +36,7 @@ var _ = Describe("PgBackRest", func() {
 			masterCluster = cluster.NewController(masterSSH, config.Master.Version, config.Master.ClusterName)
 			masterPgBackRest = pgbackrest.NewController(masterSSH, masterCluster)
 
-			err = masterPgBackRest.Backup(config.PgBackRest.Stanza)
+			_, err = masterPgBackRest.Backup(config.PgBackRest.Stanza, pgbackrest.BackupOptions{})
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -68,7 +68,7 @@ var _ = Describe("PgBackRest", func() {
 				})
 
 				By("restoring the backup", func() {
-					err = masterPgBackRest.Restore(config.PgBackRest.Stanza)
+					err = masterPgBackRest.Restore(config.PgBackRest.Stanza, pgbackrest.RestoreOptions{})
 					Expect(err).NotTo(HaveOccurred())
 				})
 			})
@@ -110,7 +110,7 @@ var _ = Describe("PgBackRest", func() {
 					})
 
 					By(fmt.Sprintf("restoring the cluster to the point in time when the data was good: %v", backupPointInTime), func() {
-						err = masterPgBackRest.RestoreToPIT(config.PgBackRest.Stanza, backupPointInTime)
+						err = masterPgBackRest.RestoreToPIT(config.PgBackRest.Stanza, "", backupPointInTime)
 						Expect(err).NotTo(HaveOccurred())
 					})
 
@@ -140,7 +140,7 @@ var _ = Describe("PgBackRest", func() {
 					})
 
 					By(fmt.Sprintf("restoring the cluster to the savepoint when the data was good: %v", savePoint), func() {
-						err = masterPgBackRest.RestoreToSavePoint(config.PgBackRest.Stanza, savePoint)
+						err = masterPgBackRest.RestoreToSavePoint(config.PgBackRest.Stanza, "", savePoint)
 						Expect(err).NotTo(HaveOccurred())
 					})
 
@@ -179,7 +179,7 @@ var _ = Describe("PgBackRest", func() {
 					})
 
 					By(fmt.Sprintf("restoring the cluster to the transaction id when the data was good: %v", txId), func() {
-						err = masterPgBackRest.RestoreToTransactionID(config.PgBackRest.Stanza, txId)
+						err = masterPgBackRest.RestoreToTransactionID(config.PgBackRest.Stanza, "", txId)
 						Expect(err).NotTo(HaveOccurred())
 					})
 
@@ -216,12 +216,12 @@ var _ = Describe("PgBackRest", func() {
 
 				It("can be restored to provide the same data as the master", func() {
 					By("creating a new backup of the master", func() {
-						err = masterPgBackRest.Backup(config.PgBackRest.Stanza)
+						_, err = masterPgBackRest.Backup(config.PgBackRest.Stanza, pgbackrest.BackupOptions{})
 						Expect(err).NotTo(HaveOccurred())
 					})
 
 					By("restoring the backup on the standby", func() {
-						err = standbyPgBackRest.Restore(config.PgBackRest.Stanza)
+						err = standbyPgBackRest.Restore(config.PgBackRest.Stanza, pgbackrest.RestoreOptions{})
 						Expect(err).NotTo(HaveOccurred())
 					})
 
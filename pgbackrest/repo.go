@@ -0,0 +1,76 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"time"
+)
+
+// fastestRepo picks the healthy repository that holds the given backup set (or any backup, if set is empty)
+// and answers quickest to listing its contents
+func (ctl Controller) fastestRepo(stanza string, info Info, set string) (int, *Error) {
+	candidates := make([]int, 0)
+
+	for _, repo := range info.Repos {
+		if !repo.OK() {
+			continue
+		}
+
+		if "" == set && 0 == len(info.BackupsInRepo(repo.Key)) {
+			continue
+		}
+
+		if "" != set && (info.Backup(set) == nil || info.Backup(set).RepoKey() != repo.Key) {
+			continue
+		}
+
+		candidates = append(candidates, repo.Key)
+	}
+
+	if 0 == len(candidates) {
+		return 0, &Error{
+			Message: fmt.Sprintf("No repository of stanza %s is available to restore from", stanza),
+			Code:    ErrorCodeRepoInvalid,
+		}
+	}
+
+	if 1 == len(candidates) {
+		return candidates[0], nil
+	}
+
+	fastest := 0
+	var fastestLatency time.Duration
+
+	for _, key := range candidates {
+		latency, err := ctl.repoLatency(stanza, key)
+
+		if err != nil {
+			continue
+		}
+
+		if 0 == fastest || latency < fastestLatency {
+			fastest = key
+			fastestLatency = latency
+		}
+	}
+
+	if 0 == fastest {
+		return 0, &Error{
+			Message: fmt.Sprintf("None of the repositories %v of stanza %s could be reached", candidates, stanza),
+			Code:    ErrorCodeRepoInvalid,
+		}
+	}
+
+	return fastest, nil
+}
+
+// repoLatency measures how long it takes to list the contents of the given repository
+func (ctl Controller) repoLatency(stanza string, repo int) (time.Duration, *Error) {
+	start := time.Now()
+	stdout, stderr, err := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s --repo=%d repo-ls", stanza, repo)
+
+	if err != nil {
+		return 0, newError(stdout, stderr, err)
+	}
+
+	return time.Since(start), nil
+}
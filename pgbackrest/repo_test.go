@@ -0,0 +1,192 @@
+package pgbackrest_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Multiple repositories", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var info pgbackrest.Info
+	var fixture string
+
+	BeforeEach(func() {
+		fixture = readFixture("info-2.36-multi-repo.json")
+
+		infos, parseErr := pgbackrest.ParseInfo(fixture)
+		Expect(parseErr).NotTo(HaveOccurred())
+		info = infos[0]
+
+		runner, ctl = fakeController("13", "info-2.36-multi-repo.json")
+	})
+
+	Context("info", func() {
+		It("has the status of each repository", func() {
+			Expect(info.Repos).To(HaveLen(3))
+			Expect(info.Repo(1).OK()).To(BeTrue())
+			Expect(info.Repo(2).OK()).To(BeTrue())
+			Expect(info.Repo(2).Cipher).To(Equal("aes-256-cbc"))
+			Expect(info.Repo(3).OK()).To(BeFalse())
+			Expect(info.Repo(3).Status.Message).To(Equal("other"))
+			Expect(info.Repo(4)).To(BeNil())
+		})
+
+		It("has the backups of each repository", func() {
+			Expect(info.BackupsInRepo(1)).To(HaveLen(2))
+			Expect(info.BackupsInRepo(2)).To(HaveLen(1))
+			Expect(info.BackupsInRepo(2)[0].Label).To(Equal("20211001-110000F"))
+			Expect(info.BackupsInRepo(3)).To(BeEmpty())
+		})
+
+		It("can be restricted to a single repository", func() {
+			_, err := ctl.InfoForRepo("pitr", 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest info --stanza=pitr --output=json --repo=2"))
+		})
+	})
+
+	Context("backup", func() {
+		It("backs up into the given repository", func() {
+			runner.Once("pgbackrest info", withoutBackup(fixture, "20211001-110000F"), "", nil)
+
+			label, err := ctl.Backup("pitr", pgbackrest.BackupOptions{Type: pgbackrest.BackupTypeFull, Repo: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(label).To(Equal("20211001-110000F"))
+			Expect(runner.CommandsContaining("backup")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr backup --type=full --repo=2"))
+			Expect(runner.CommandsContaining("pgbackrest info")).To(ConsistOf(
+				"sudo --user postgres pgbackrest info --stanza=pitr --output=json --repo=2",
+				"sudo --user postgres pgbackrest info --stanza=pitr --output=json --repo=2",
+			))
+		})
+
+		It("reports the new backup of the first repository by default", func() {
+			runner.Once("pgbackrest info", withoutBackup(fixture, "20211001-100000F_20211002-120000I"), "", nil)
+
+			label, err := ctl.Backup("pitr", pgbackrest.BackupOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(label).To(Equal("20211001-100000F_20211002-120000I"))
+		})
+
+		It("does not mistake a backup in another repository for the new one", func() {
+			runner.Once("pgbackrest info", withoutBackup(fixture, "20211001-110000F"), "", nil)
+
+			_, err := ctl.Backup("pitr", pgbackrest.BackupOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Message).To(ContainSubstring("Could not find the new backup"))
+		})
+	})
+
+	Context("restore", func() {
+		var options pgbackrest.RestoreOptions
+		var err *pgbackrest.Error
+
+		BeforeEach(func() {
+			options = pgbackrest.RestoreOptions{}
+		})
+
+		JustBeforeEach(func() {
+			err = ctl.Restore("pitr", options)
+		})
+
+		Context("from a given repository", func() {
+			BeforeEach(func() {
+				options.Repo = 2
+			})
+
+			It("does not measure", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("repo-ls")).To(BeEmpty())
+				Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --repo=2 restore"))
+			})
+		})
+
+		Context("from an unhealthy repository", func() {
+			BeforeEach(func() {
+				options.Repo = 3
+			})
+
+			It("does not touch the cluster", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeRepoInvalid))
+				Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+			})
+		})
+
+		Context("a set that is in another repository", func() {
+			BeforeEach(func() {
+				options.Repo = 1
+				options.Set = "20211001-110000F"
+			})
+
+			It("does not touch the cluster", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeBackupSetInvalid))
+				Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+			})
+		})
+
+		Context("a set that is in one repository only", func() {
+			BeforeEach(func() {
+				options.Set = "20211001-110000F"
+			})
+
+			It("picks that repository without measuring", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("repo-ls")).To(BeEmpty())
+				Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --set='20211001-110000F' --repo=2 restore"))
+			})
+		})
+
+		Context("without a repository", func() {
+			BeforeEach(func() {
+				runner.Delay("--repo=1 repo-ls", 50*time.Millisecond)
+			})
+
+			It("measures the healthy repositories that have backups", func() {
+				Expect(runner.CommandsContaining("repo-ls")).To(ConsistOf(
+					"sudo --user postgres pgbackrest --stanza=pitr --repo=1 repo-ls",
+					"sudo --user postgres pgbackrest --stanza=pitr --repo=2 repo-ls",
+				))
+			})
+
+			It("picks the fastest one", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --repo=2 restore"))
+			})
+
+			Context("excluding a database", func() {
+				BeforeEach(func() {
+					// only the latest backup of all, which is in repository 1, has the database "reporting"
+					renamed := strings.Replace(fixture, `"name": "sandbox"`, `"name": "reporting"`, -1)
+					renamed = strings.Replace(renamed, `"name": "reporting"`, `"name": "sandbox"`, 2)
+					runner.On("pgbackrest info", renamed, "", nil)
+
+					options.ExcludeDatabases = []string{"reporting"}
+				})
+
+				It("validates against the latest backup of the chosen repository", func() {
+					Expect(err).To(HaveOccurred())
+					Expect(err.Code).To(Equal(pgbackrest.ErrorCodeDbMissing))
+					Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+				})
+			})
+
+			Context("the fastest repository cannot be reached", func() {
+				BeforeEach(func() {
+					runner.On("--repo=2 repo-ls", "", "ERROR: [039]: unable to connect to S3", h.ExitError{Status: 39})
+				})
+
+				It("picks the other one", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --repo=1 restore"))
+				})
+			})
+		})
+	})
+})
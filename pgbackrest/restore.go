@@ -0,0 +1,605 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+)
+
+// Recovery target types supported by pgbackrest
+const (
+	TargetTypeDefault   = "default"
+	TargetTypeImmediate = "immediate"
+	TargetTypeTime      = "time"
+	TargetTypeName      = "name"
+	TargetTypeXID       = "xid"
+	TargetTypeLSN       = "lsn"
+	TargetTypeStandby   = "standby"
+)
+
+// Actions taken by PostgreSQL once the recovery target is reached
+const (
+	TargetActionPause    = "pause"
+	TargetActionPromote  = "promote"
+	TargetActionShutdown = "shutdown"
+)
+
+// RestoreOptions control how a backup is restored. The zero value makes a delta restore
+// of the latest backup and replays all available WAL.
+type RestoreOptions struct {
+	// Set is the label of the backup to restore; empty restores the latest backup
+	Set string
+
+	// Annotations select the latest backup carrying all of them; cannot be combined with Set
+	Annotations map[string]string
+
+	// Type is one of the TargetType constants; empty is the same as TargetTypeDefault
+	Type string
+
+	// Target is the savepoint, transaction ID or LSN to recover to, depending on Type
+	Target string
+
+	// TargetTime is the point in time to recover to if Type is TargetTypeTime
+	TargetTime time.Time
+
+	// TargetExclusive stops recovery just before the target instead of just after it
+	TargetExclusive bool
+
+	// TargetAction is one of the TargetAction constants; empty keeps the PostgreSQL default (pause)
+	TargetAction string
+
+	// TargetTimeline is the timeline to recover along, e.g. "latest", "current" or a timeline ID; empty keeps the PostgreSQL default
+	TargetTimeline string
+
+	// Clean empties the data directory before restoring instead of making a delta restore
+	Clean bool
+
+	// Force makes a delta restore determine changed files by size and timestamp only
+	Force bool
+
+	// ProcessMax is the number of processes used for decompression and transfer; 0 keeps the configured value
+	ProcessMax int
+
+	// Repo is the key of the repository to restore from; 0 picks the fastest repository holding the backup
+	Repo int
+
+	// IncludeDatabases restricts the restore to the given databases (plus the system databases)
+	IncludeDatabases []string
+
+	// ExcludeDatabases are not restored; they remain in the catalog, but cannot be used and should be dropped
+	ExcludeDatabases []string
+}
+
+// systemDatabases are always restored by pgbackrest
+var systemDatabases = map[string]bool{
+	"postgres":  true,
+	"template0": true,
+	"template1": true,
+}
+
+// Restore stops the cluster, restores a backup of the given stanza and starts the cluster again.
+// Everything that can be validated upfront is checked before the cluster is stopped.
+// With TargetActionShutdown, the cluster is left stopped; it recovers to the target once it is
+// started, and then shuts down again.
+func (ctl Controller) Restore(stanza string, options RestoreOptions) *Error {
+	if TargetActionShutdown == options.TargetAction && options.isSelective() {
+		return &Error{
+			Message: "The databases that are not restored can only be verified in a running cluster; target action shutdown stops it",
+			Code:    ErrorCodeOptionInvalid,
+		}
+	}
+
+	args, excluded, err := ctl.prepareRestore(stanza, options)
+
+	if err != nil {
+		return err
+	}
+
+	err = fromPitrError(ctl.cluster.Stop())
+
+	if err != nil {
+		return err
+	}
+
+	if options.Clean {
+		err = fromPitrError(ctl.cluster.Clear())
+
+		if err != nil {
+			return err
+		}
+	}
+
+	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s%s restore", stanza, args)
+
+	if runErr != nil {
+		return newError(stdout, stderr, runErr)
+	}
+
+	if TargetActionShutdown == options.TargetAction {
+		return nil
+	}
+
+	err = fromPitrError(ctl.cluster.Start())
+
+	if err != nil {
+		return err
+	}
+
+	return ctl.verifyExcluded(ctl.cluster, excluded)
+}
+
+// prepareRestore validates the options and the backup to be restored. It returns the arguments for pgbackrest
+// and the databases that will not be restored.
+func (ctl Controller) prepareRestore(stanza string, options RestoreOptions) (string, []string, *Error) {
+	args, err := options.args()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	info, err := ctl.stanzaInfo(stanza)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if 0 < len(options.Annotations) {
+		options.Set, err = annotatedSet(stanza, *info, options)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		args, err = options.args()
+
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if TargetTypeLSN == options.Type {
+		version, parseErr := pitr.ParseVersion(ctl.cluster.Version)
+
+		if parseErr != nil {
+			return "", nil, &Error{
+				Message: parseErr.Error(),
+			}
+		}
+
+		if version.Compare(pitr.FirstVersionWithLSNTarget) < 0 {
+			return "", nil, &Error{
+				Message: fmt.Sprintf("PostgreSQL %s cannot recover to an LSN", version.MajorString()),
+				Code:    ErrorCodeOptionInvalid,
+			}
+		}
+	}
+
+	// pick the repository first, so that the backup is validated against the latest set of that repository
+	if 0 == options.Repo && len(info.Repos) > 1 {
+		options.Repo, err = ctl.fastestRepo(stanza, *info, options.Set)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		args, err = options.args()
+
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	backup, err := selectBackup(stanza, *info, options)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	// after a stanza upgrade, older backups were made of the cluster of the previous version
+	database := info.BackupDatabase(*backup)
+
+	if database == nil {
+		return "", nil, &Error{
+			Message: fmt.Sprintf("Stanza %s does not know the cluster backup %s was made of", stanza, backup.Label),
+			Code:    ErrorCodeBackupSetInvalid,
+		}
+	}
+
+	err = ctl.validateVersion(stanza, *database)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	excluded, err := validateBackup(*backup, options)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return args, excluded, nil
+}
+
+// RestoreToPIT a specific point in time. If set is empty, pgbackrest picks the backup.
+func (ctl Controller) RestoreToPIT(stanza, set string, pointInTime time.Time) *Error {
+	return ctl.Restore(stanza, RestoreOptions{Set: set, Type: TargetTypeTime, TargetTime: pointInTime})
+}
+
+// RestoreToSavePoint restores to the given savepoint. If set is empty, the latest backup is restored.
+func (ctl Controller) RestoreToSavePoint(stanza, set string, savePoint string) *Error {
+	return ctl.Restore(stanza, RestoreOptions{Set: set, Type: TargetTypeName, Target: savePoint})
+}
+
+// RestoreToTransactionID restores to the given transaction ID. If set is empty, the latest backup is restored.
+func (ctl Controller) RestoreToTransactionID(stanza, set string, txID int64) *Error {
+	return ctl.Restore(stanza, RestoreOptions{Set: set, Type: TargetTypeXID, Target: fmt.Sprintf("%d", txID)})
+}
+
+// RestoreToLSN restores to the given position in the WAL. If set is empty, the latest backup is restored.
+func (ctl Controller) RestoreToLSN(stanza, set string, lsn pitr.LSN) *Error {
+	return ctl.Restore(stanza, RestoreOptions{Set: set, Type: TargetTypeLSN, Target: lsn.String()})
+}
+
+// RestoreAnnotated restores the latest backup carrying all of the given annotations and replays all available WAL
+func (ctl Controller) RestoreAnnotated(stanza string, annotations map[string]string) *Error {
+	return ctl.Restore(stanza, RestoreOptions{Annotations: annotations})
+}
+
+// annotatedSet returns the label of the latest backup (in the requested repository) carrying all annotations of the options
+func annotatedSet(stanza string, info Info, options RestoreOptions) (string, *Error) {
+	if "" != options.Set {
+		return "", &Error{
+			Message: "Either a set or annotations may be given, but not both",
+			Code:    ErrorCodeOptionInvalid,
+		}
+	}
+
+	if options.Repo > 0 {
+		info.Backups = info.BackupsInRepo(options.Repo)
+	}
+
+	backup := info.LatestAnnotatedBackup(options.Annotations)
+
+	if backup == nil {
+		return "", &Error{
+			Message: fmt.Sprintf("Stanza %s has no backup annotated with %s", stanza, strings.Join(sortedAnnotations(options.Annotations), ", ")),
+			Code:    ErrorCodeBackupSetInvalid,
+		}
+	}
+
+	return backup.Label, nil
+}
+
+// selectBackup returns the backup set to be restored, making sure that it exists (in the requested repository)
+func selectBackup(stanza string, info Info, options RestoreOptions) (*Backup, *Error) {
+	if options.Repo > 0 && len(info.Repos) > 0 {
+		repo := info.Repo(options.Repo)
+
+		if repo == nil || !repo.OK() {
+			return nil, &Error{
+				Message: fmt.Sprintf("Repository %d of stanza %s is not available", options.Repo, stanza),
+				Code:    ErrorCodeRepoInvalid,
+			}
+		}
+	}
+
+	var backup *Backup
+
+	if "" == options.Set {
+		backup = info.LatestBackup()
+
+		if options.Repo > 0 {
+			backup = nil
+
+			if backups := info.BackupsInRepo(options.Repo); 0 < len(backups) {
+				backup = &backups[len(backups)-1]
+			}
+		}
+
+		if backup == nil {
+			return nil, &Error{
+				Message: fmt.Sprintf("Stanza %s has no backups", stanza),
+				Code:    ErrorCodeBackupSetInvalid,
+			}
+		}
+	} else {
+		backup = info.Backup(options.Set)
+
+		if backup == nil {
+			return nil, &Error{
+				Message: fmt.Sprintf("Stanza %s has no backup %s", stanza, options.Set),
+				Code:    ErrorCodeBackupSetInvalid,
+			}
+		}
+
+		if options.Repo > 0 && backup.RepoKey() != options.Repo {
+			return nil, &Error{
+				Message: fmt.Sprintf("Backup %s is not stored in repository %d, but in %d", options.Set, options.Repo, backup.RepoKey()),
+				Code:    ErrorCodeBackupSetInvalid,
+			}
+		}
+	}
+
+	return backup, nil
+}
+
+// validateBackup makes sure that, if recovering to a point in time or an LSN, the given set was finished before
+// it. Targets of type xid and name cannot be checked in advance; only recovery finds out whether they come after
+// the set. It also resolves the databases to be restored against those in the backup and returns the databases
+// that will not be restored.
+func validateBackup(backup Backup, options RestoreOptions) ([]string, *Error) {
+	if "" != options.Set {
+		if TargetTypeTime == options.Type && !options.TargetTime.After(backup.StopTime()) {
+			return nil, &Error{
+				Message: fmt.Sprintf("Cannot restore backup %s to %s because the backup was finished later, at %s", options.Set, options.TargetTime.Format(time.RFC3339), backup.StopTime().Format(time.RFC3339)),
+				Code:    ErrorCodeBackupSetInvalid,
+			}
+		}
+
+		if TargetTypeLSN == options.Type && "" != backup.LSN.Stop {
+			err := validateLSNTarget(backup, options.Target)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !options.isSelective() {
+		return nil, nil
+	}
+
+	return resolveDatabases(backup, options)
+}
+
+// validateLSNTarget makes sure that the target LSN is not before the end of the backup
+func validateLSNTarget(backup Backup, target string) *Error {
+	targetLSN, parseErr := pitr.ParseLSN(target)
+
+	if parseErr != nil {
+		return &Error{
+			Message: parseErr.Error(),
+			Code:    ErrorCodeOptionInvalidValue,
+		}
+	}
+
+	stopLSN, parseErr := pitr.ParseLSN(backup.LSN.Stop)
+
+	if parseErr != nil {
+		return &Error{
+			Message: parseErr.Error(),
+			Code:    ErrorCodeFormat,
+		}
+	}
+
+	if targetLSN < stopLSN {
+		return &Error{
+			Message: fmt.Sprintf("Cannot restore backup %s to %s because the backup ended later, at %s", backup.Label, targetLSN, stopLSN),
+			Code:    ErrorCodeBackupSetInvalid,
+		}
+	}
+
+	return nil
+}
+
+// resolveDatabases checks the included and excluded databases against those in the backup
+// and returns the databases that will not be restored
+func resolveDatabases(backup Backup, options RestoreOptions) ([]string, *Error) {
+	if 0 == len(backup.Databases) {
+		return nil, &Error{
+			Message: fmt.Sprintf("Backup %s has no list of databases; it may have been made with a pgbackrest version that is too old", backup.Label),
+			Code:    ErrorCodeDbMissing,
+		}
+	}
+
+	inBackup := make(map[string]bool)
+
+	for _, database := range backup.Databases {
+		inBackup[database.Name] = true
+	}
+
+	included := make(map[string]bool)
+	excluded := make(map[string]bool)
+
+	for _, selection := range []struct {
+		names []string
+		into  map[string]bool
+	}{{options.IncludeDatabases, included}, {options.ExcludeDatabases, excluded}} {
+		for _, name := range selection.names {
+			if systemDatabases[name] {
+				return nil, &Error{
+					Message: fmt.Sprintf("%s is a system database, which is always restored", name),
+					Code:    ErrorCodeDbInvalid,
+				}
+			}
+
+			if !inBackup[name] {
+				return nil, &Error{
+					Message: fmt.Sprintf("Backup %s has no database %s", backup.Label, name),
+					Code:    ErrorCodeDbMissing,
+				}
+			}
+
+			selection.into[name] = true
+		}
+	}
+
+	var notRestored []string
+
+	for _, database := range backup.Databases {
+		if systemDatabases[database.Name] {
+			continue
+		}
+
+		if included[database.Name] && excluded[database.Name] {
+			return nil, &Error{
+				Message: fmt.Sprintf("Database %s cannot be both included and excluded", database.Name),
+				Code:    ErrorCodeOptionInvalid,
+			}
+		}
+
+		if excluded[database.Name] || (0 < len(included) && !included[database.Name]) {
+			notRestored = append(notRestored, database.Name)
+		}
+	}
+
+	return notRestored, nil
+}
+
+// startupTimeout limits how long a restored cluster may take until it accepts connections
+const startupTimeout = time.Minute
+
+// verifyExcluded makes sure that databases that were not restored cannot be used by accident. Once the
+// cluster accepts connections, pgbackrest's zeroed files must make each of them fail with an invalid relation
+// mapping file; any other error leaves it open whether the database was restored.
+func (ctl Controller) verifyExcluded(target cluster.Controller, databases []string) *Error {
+	if 0 == len(databases) {
+		return nil
+	}
+
+	err := ctl.waitFor(startupTimeout, func() (bool, *Error) {
+		_, pitrErr := target.Query("select 1")
+		return pitrErr == nil, nil
+	}, fmt.Sprintf("Cluster %s/%s does not accept connections after %s", target.Version, target.Name, startupTimeout))
+
+	if err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		_, pitrErr := target.QueryDatabase(database, "select 1")
+
+		if pitrErr == nil {
+			return &Error{
+				Message: fmt.Sprintf("Database %s was not restored, but can still be used", database),
+			}
+		}
+
+		if !strings.Contains(pitrErr.Stderr, "relation mapping file") {
+			return &Error{
+				Message: fmt.Sprintf("Could not verify that database %s was not restored: %s", database, pitrErr.Message),
+				Stdout:  pitrErr.Stdout,
+				Stderr:  pitrErr.Stderr,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (options RestoreOptions) isSelective() bool {
+	return 0 < len(options.IncludeDatabases)+len(options.ExcludeDatabases)
+}
+
+func (options RestoreOptions) args() (string, *Error) {
+	var args strings.Builder
+
+	if !options.Clean {
+		args.WriteString(" --delta")
+	}
+
+	if options.Force {
+		if options.Clean {
+			return "", &Error{
+				Message: "Force only applies to delta restores",
+				Code:    ErrorCodeOptionInvalid,
+			}
+		}
+
+		args.WriteString(" --force")
+	}
+
+	if "" != options.Set {
+		args.WriteString(" --set=" + shellQuote(options.Set))
+	}
+
+	switch options.Type {
+	case "", TargetTypeDefault:
+		if "" != options.Target || "" != options.TargetAction || options.TargetExclusive {
+			return "", &Error{
+				Message: "A recovery target requires a target type",
+				Code:    ErrorCodeOptionInvalid,
+			}
+		}
+	case TargetTypeImmediate, TargetTypeStandby:
+		if "" != options.Target {
+			return "", &Error{
+				Message: fmt.Sprintf("Recovery target type %s does not take a target", options.Type),
+				Code:    ErrorCodeOptionInvalid,
+			}
+		}
+
+		args.WriteString(" --type=" + options.Type)
+	case TargetTypeTime:
+		if options.TargetTime.IsZero() {
+			return "", &Error{
+				Message: "Recovery to a point in time requires a target time",
+				Code:    ErrorCodeOptionRequired,
+			}
+		}
+
+		fmt.Fprintf(&args, " --type=time --target=%s", shellQuote(options.TargetTime.Format(time.RFC3339Nano)))
+	case TargetTypeName, TargetTypeXID, TargetTypeLSN:
+		if "" == options.Target {
+			return "", &Error{
+				Message: fmt.Sprintf("Recovery target type %s requires a target", options.Type),
+				Code:    ErrorCodeOptionRequired,
+			}
+		}
+
+		fmt.Fprintf(&args, " --type=%s --target=%s", options.Type, shellQuote(options.Target))
+	default:
+		return "", &Error{
+			Message: fmt.Sprintf("Unknown recovery target type '%s'", options.Type),
+			Code:    ErrorCodeOptionInvalidValue,
+		}
+	}
+
+	if options.TargetExclusive {
+		switch options.Type {
+		case TargetTypeTime, TargetTypeXID, TargetTypeLSN:
+			args.WriteString(" --target-exclusive")
+		default:
+			return "", &Error{
+				Message: fmt.Sprintf("Recovery target type %s cannot be exclusive", options.Type),
+				Code:    ErrorCodeOptionInvalid,
+			}
+		}
+	}
+
+	switch options.TargetAction {
+	case "":
+	case TargetActionPause, TargetActionPromote, TargetActionShutdown:
+		args.WriteString(" --target-action=" + options.TargetAction)
+	default:
+		return "", &Error{
+			Message: fmt.Sprintf("Unknown recovery target action '%s'", options.TargetAction),
+			Code:    ErrorCodeOptionInvalidValue,
+		}
+	}
+
+	if "" != options.TargetTimeline {
+		args.WriteString(" --target-timeline=" + shellQuote(options.TargetTimeline))
+	}
+
+	if options.ProcessMax > 0 {
+		fmt.Fprintf(&args, " --process-max=%d", options.ProcessMax)
+	}
+
+	if options.Repo > 0 {
+		fmt.Fprintf(&args, " --repo=%d", options.Repo)
+	}
+
+	for _, database := range options.IncludeDatabases {
+		args.WriteString(" --db-include=" + shellQuote(database))
+	}
+
+	for _, database := range options.ExcludeDatabases {
+		args.WriteString(" --db-exclude=" + shellQuote(database))
+	}
+
+	return args.String(), nil
+}
@@ -0,0 +1,474 @@
+package pgbackrest_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Restore", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "info-2.19.json")
+	})
+
+	It("restores the latest backup", func() {
+		err = ctl.Restore("pitr", pgbackrest.RestoreOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta restore"))
+	})
+
+	It("restores the given set", func() {
+		err = ctl.Restore("pitr", pgbackrest.RestoreOptions{Set: "20191126-101005F"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --set='20191126-101005F' restore"))
+	})
+
+	It("restores the given set to a savepoint", func() {
+		err = ctl.RestoreToSavePoint("pitr", "20191126-101005F", "before-migration")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf(`sudo --user postgres pgbackrest --stanza=pitr --delta --set='20191126-101005F' --type=name --target='before-migration' restore`))
+	})
+
+	It("quotes a savepoint name for the shell", func() {
+		err = ctl.RestoreToSavePoint("pitr", "20191126-101005F", `before "$(migration)" it's done`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf(`sudo --user postgres pgbackrest --stanza=pitr --delta --set='20191126-101005F' --type=name --target='before "$(migration)" it'\''s done' restore`))
+	})
+
+	It("restores the given set to a transaction", func() {
+		err = ctl.RestoreToTransactionID("pitr", "20191126-101005F", 4711)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf(`sudo --user postgres pgbackrest --stanza=pitr --delta --set='20191126-101005F' --type=xid --target='4711' restore`))
+	})
+
+	It("restores to an LSN", func() {
+		err = ctl.RestoreToLSN("pitr", "", pitr.LSN(0x16B374D848))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf(`sudo --user postgres pgbackrest --stanza=pitr --delta --type=lsn --target='16/B374D848' restore`))
+	})
+
+	Context("the set does not exist", func() {
+		It("does not stop the cluster", func() {
+			err = ctl.Restore("pitr", pgbackrest.RestoreOptions{Set: "20191231-000000F"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeBackupSetInvalid))
+			Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+			Expect(runner.CommandsContaining("restore")).To(BeEmpty())
+		})
+	})
+
+	Context("restoring a set to a point in time", func() {
+		var pointInTime time.Time
+
+		JustBeforeEach(func() {
+			err = ctl.RestoreToPIT("pitr", "20191126-101005F_20191126-101520D", pointInTime)
+		})
+
+		Context("after the set was finished", func() {
+			BeforeEach(func() {
+				pointInTime = time.Unix(1574763324, 0).Add(time.Minute).UTC()
+			})
+
+			It("restores the set", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("restore")).To(ConsistOf(`sudo --user postgres pgbackrest --stanza=pitr --delta --set='20191126-101005F_20191126-101520D' --type=time --target='2019-11-26T10:16:24Z' restore`))
+			})
+		})
+
+		Context("before the set was finished", func() {
+			BeforeEach(func() {
+				pointInTime = time.Unix(1574763324, 0).Add(-time.Second)
+			})
+
+			It("does not stop the cluster", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeBackupSetInvalid))
+				Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("restoring a set to an LSN", func() {
+		var lsn pitr.LSN
+
+		BeforeEach(func() {
+			runner.On("pgbackrest info", readFixture("info-2.33.json"), "", nil)
+
+			ctl = pgbackrest.NewController(runner, cluster.NewController(runner, "13", "main"))
+		})
+
+		JustBeforeEach(func() {
+			err = ctl.RestoreToLSN("pitr", "20210412-093047F", lsn)
+		})
+
+		Context("after the set ended", func() {
+			BeforeEach(func() {
+				lsn = pitr.LSN(0x5000200)
+			})
+
+			It("restores the set", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("restore")).To(ConsistOf(`sudo --user postgres pgbackrest --stanza=pitr --delta --set='20210412-093047F' --type=lsn --target='0/5000200' restore`))
+			})
+		})
+
+		Context("before the set ended", func() {
+			BeforeEach(func() {
+				lsn = pitr.LSN(0x5000100)
+			})
+
+			It("does not stop the cluster", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeBackupSetInvalid))
+				Expect(err.Message).To(ContainSubstring("0/5000138"))
+				Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("options", func() {
+		var options pgbackrest.RestoreOptions
+
+		BeforeEach(func() {
+			options = pgbackrest.RestoreOptions{}
+		})
+
+		JustBeforeEach(func() {
+			err = ctl.Restore("pitr", options)
+		})
+
+		Context("all options are set", func() {
+			BeforeEach(func() {
+				options = pgbackrest.RestoreOptions{
+					Type:            pgbackrest.TargetTypeLSN,
+					Target:          "0/3000060",
+					TargetExclusive: true,
+					TargetAction:    pgbackrest.TargetActionPromote,
+					TargetTimeline:  "latest",
+					Force:           true,
+					ProcessMax:      4,
+				}
+			})
+
+			It("passes them on", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("restore")).To(ConsistOf(`sudo --user postgres pgbackrest --stanza=pitr --delta --force --type=lsn --target='0/3000060' --target-exclusive --target-action=promote --target-timeline='latest' --process-max=4 restore`))
+			})
+		})
+
+		Context("recovering until consistency", func() {
+			BeforeEach(func() {
+				options.Type = pgbackrest.TargetTypeImmediate
+				options.TargetAction = pgbackrest.TargetActionShutdown
+			})
+
+			It("needs no target", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --type=immediate --target-action=shutdown restore"))
+			})
+
+			It("leaves the cluster stopped", func() {
+				Expect(runner.CommandsContaining("pg_ctlcluster 11 main stop")).To(HaveLen(1))
+				Expect(runner.CommandsContaining("pg_ctlcluster 11 main start")).To(BeEmpty())
+			})
+
+			Context("some databases are excluded", func() {
+				BeforeEach(func() {
+					options.ExcludeDatabases = []string{"sandbox"}
+				})
+
+				It("does not touch the cluster, because the exclusion cannot be verified", func() {
+					Expect(err).To(HaveOccurred())
+					Expect(err.Code).To(Equal(pgbackrest.ErrorCodeOptionInvalid))
+					Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("clean restore", func() {
+			BeforeEach(func() {
+				options.Clean = true
+			})
+
+			It("empties the data directory after stopping the cluster", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("find /var/lib/postgresql/11/main -mindepth 1 -delete")).To(HaveLen(1))
+				Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr restore"))
+			})
+
+			Context("with force", func() {
+				BeforeEach(func() {
+					options.Force = true
+				})
+
+				It("is rejected", func() {
+					Expect(err).To(HaveOccurred())
+					Expect(err.IsMisconfiguration()).To(BeTrue())
+					Expect(runner.Commands).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("the target is missing", func() {
+			BeforeEach(func() {
+				options.Type = pgbackrest.TargetTypeXID
+			})
+
+			It("does not touch the cluster", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeOptionRequired))
+				Expect(runner.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("a target is given without type", func() {
+			BeforeEach(func() {
+				options.Target = "before-migration"
+			})
+
+			It("is rejected", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(runner.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("the target type cannot be exclusive", func() {
+			BeforeEach(func() {
+				options.Type = pgbackrest.TargetTypeName
+				options.Target = "before-migration"
+				options.TargetExclusive = true
+			})
+
+			It("is rejected", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(runner.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("the target action is unknown", func() {
+			BeforeEach(func() {
+				options.Type = pgbackrest.TargetTypeImmediate
+				options.TargetAction = "explode"
+			})
+
+			It("is rejected", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodeOptionInvalidValue))
+				Expect(runner.Commands).To(BeEmpty())
+			})
+		})
+	})
+})
+
+var _ = Describe("Selective restore", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var options pgbackrest.RestoreOptions
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("13", "info-2.33.json")
+		runner.On("--dbname=sandbox", "", `FATAL:  relation mapping file "base/16384/pg_filenode.map" contains invalid data`, h.ExitError{Status: 2})
+		options = pgbackrest.RestoreOptions{}
+	})
+
+	JustBeforeEach(func() {
+		err = ctl.Restore("pitr", options)
+	})
+
+	Context("excluding a database", func() {
+		BeforeEach(func() {
+			options.ExcludeDatabases = []string{"sandbox"}
+		})
+
+		It("passes the database", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --db-exclude='sandbox' restore"))
+		})
+
+		It("verifies that the excluded database cannot be used after startup", func() {
+			Expect(runner.CommandsContaining("--dbname=sandbox")).To(HaveLen(1))
+		})
+
+		Context("the excluded database can still be used", func() {
+			BeforeEach(func() {
+				runner.On("--dbname=sandbox", "1", "", nil)
+			})
+
+			It("fails", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Message).To(ContainSubstring("sandbox"))
+			})
+		})
+
+		Context("the cluster is still starting up", func() {
+			BeforeEach(func() {
+				ctl.PollInterval = 0
+				runner.Once("--dbname=postgres", "", "FATAL:  the database system is starting up", h.ExitError{Status: 2})
+			})
+
+			It("waits until it accepts connections before verifying", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("--dbname=postgres")).To(HaveLen(2))
+				Expect(runner.CommandsContaining("--dbname=sandbox")).To(HaveLen(1))
+			})
+		})
+
+		Context("the excluded database fails for another reason", func() {
+			BeforeEach(func() {
+				runner.On("--dbname=sandbox", "", "FATAL:  sorry, too many clients already", h.ExitError{Status: 2})
+			})
+
+			It("fails", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Message).To(ContainSubstring("Could not verify"))
+			})
+		})
+	})
+
+	Context("including only the system databases", func() {
+		BeforeEach(func() {
+			options.IncludeDatabases = []string{"postgres"}
+		})
+
+		It("does not touch the cluster", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeDbInvalid))
+			Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+		})
+	})
+
+	Context("including a database that is not in the backup", func() {
+		BeforeEach(func() {
+			options.IncludeDatabases = []string{"production"}
+		})
+
+		It("does not touch the cluster", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeDbMissing))
+			Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+		})
+	})
+
+	Context("including a database", func() {
+		BeforeEach(func() {
+			runner.On("--dbname=sandbox", "1", "", nil)
+			options.IncludeDatabases = []string{"sandbox"}
+		})
+
+		It("restores it", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --db-include='sandbox' restore"))
+			Expect(runner.CommandsContaining("--dbname=sandbox")).To(BeEmpty())
+		})
+	})
+
+	Context("the backup has no list of databases", func() {
+		BeforeEach(func() {
+			runner.On("pgbackrest info", readFixture("info-2.19.json"), "", nil)
+
+			ctl = pgbackrest.NewController(runner, cluster.NewController(runner, "11", "main"))
+			options.ExcludeDatabases = []string{"sandbox"}
+		})
+
+		It("does not touch the cluster", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeDbMissing))
+			Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Restoring after a stanza upgrade", func() {
+	var runner *h.FakeRunner
+
+	BeforeEach(func() {
+		runner, _ = fakeController("11", "info-2.19-upgraded.json")
+	})
+
+	It("restores a set of the previous version into a cluster of that version", func() {
+		err := pgbackrest.NewController(runner, cluster.NewController(runner, "10", "main")).Restore("pitr", pgbackrest.RestoreOptions{Set: "20191126-101005F_20191126-101520D"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --set='20191126-101005F_20191126-101520D' restore"))
+	})
+
+	It("does not restore a set of the previous version into a cluster of the current one", func() {
+		err := pgbackrest.NewController(runner, cluster.NewController(runner, "11", "main")).Restore("pitr", pgbackrest.RestoreOptions{Set: "20191126-101005F"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Message).To(ContainSubstring("backups of version 10"))
+		Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+	})
+
+	It("does not restore the latest set into a cluster of the previous version", func() {
+		err := pgbackrest.NewController(runner, cluster.NewController(runner, "10", "main")).Restore("pitr", pgbackrest.RestoreOptions{})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Message).To(ContainSubstring("backups of version 11"))
+		Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Restoring to an LSN before PostgreSQL 10", func() {
+	It("does not stop the cluster", func() {
+		runner, ctl := fakeController("9.6", "")
+		runner.On("pgbackrest info", strings.Replace(readFixture("info-2.19.json"), `"version": "11"`, `"version": "9.6"`, -1), "", nil)
+
+		err := ctl.RestoreToLSN("pitr", "", pitr.LSN(0x3000060))
+		Expect(err).To(HaveOccurred())
+		Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Restoring by annotation", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var options pgbackrest.RestoreOptions
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "info-2.41-annotations.json")
+		options = pgbackrest.RestoreOptions{Annotations: map[string]string{"marker": "pre-migration"}}
+	})
+
+	JustBeforeEach(func() {
+		err = ctl.Restore("pitr", options)
+	})
+
+	It("restores the latest matching backup", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.CommandsContaining("restore")).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --delta --set='20191126-101005F_20191126-101520D' restore"))
+	})
+
+	Context("no backup matches", func() {
+		BeforeEach(func() {
+			options.Annotations = map[string]string{"ticket": "OPS-99"}
+		})
+
+		It("does not stop the cluster", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Message).To(ContainSubstring("ticket=OPS-99"))
+			Expect(runner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+		})
+	})
+
+	Context("a set is given, too", func() {
+		BeforeEach(func() {
+			options.Set = "20191126-101005F"
+		})
+
+		It("is rejected", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeOptionInvalid))
+			Expect(runner.CommandsContaining("restore")).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,95 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"strings"
+
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+)
+
+// Standby is a hot standby of the cluster that backups may copy their files from
+type Standby struct {
+	Runner  pitr.Runner
+	Cluster cluster.Controller
+
+	// Host is the name or address pgbackrest on the primary uses to reach the standby (pg2-host)
+	Host string
+}
+
+// WithStandby returns a controller that is able to back up from the given standby
+func (ctl Controller) WithStandby(standby Standby) Controller {
+	ctl.standby = &standby
+	return ctl
+}
+
+// ValidateStandby makes sure that backups can be taken from the standby: it must be in recovery,
+// stream WAL from the primary, and run the same pgbackrest version as the primary.
+func (ctl Controller) ValidateStandby() *Error {
+	if ctl.standby == nil {
+		return &Error{
+			Message: "No standby is known to back up from",
+			Code:    ErrorCodeOptionInvalid,
+		}
+	}
+
+	inRecovery, err := ctl.standby.Cluster.IsInRecovery()
+
+	if err != nil {
+		return fromPitrError(err)
+	}
+
+	if !inRecovery {
+		return &Error{
+			Message: fmt.Sprintf("The cluster on %s is not a standby", ctl.standby.Host),
+		}
+	}
+
+	status, err := ctl.standby.Cluster.WALReceiverStatus()
+
+	if err != nil {
+		return fromPitrError(err)
+	}
+
+	if "streaming" != status {
+		return &Error{
+			Message: fmt.Sprintf("The standby on %s is not replicating; its WAL receiver is '%s'", ctl.standby.Host, status),
+		}
+	}
+
+	primaryVersion, versionErr := pgBackRestVersion(ctl.runner)
+
+	if versionErr != nil {
+		return versionErr
+	}
+
+	standbyVersion, versionErr := pgBackRestVersion(ctl.standby.Runner)
+
+	if versionErr != nil {
+		return versionErr
+	}
+
+	if primaryVersion != standbyVersion {
+		return &Error{
+			Message: fmt.Sprintf("The primary runs %s, but the standby on %s runs %s", primaryVersion, ctl.standby.Host, standbyVersion),
+		}
+	}
+
+	return nil
+}
+
+// standbyArgs tells pgbackrest where to find the standby
+func (ctl Controller) standbyArgs() string {
+	return fmt.Sprintf(" --pg2-host=%s --pg2-path=%s", shellQuote(ctl.standby.Host), ctl.standby.Cluster.DataDirectory())
+}
+
+// pgBackRestVersion returns the version string of pgbackrest, e.g. "pgBackRest 2.36"
+func pgBackRestVersion(runner pitr.Runner) (string, *Error) {
+	stdout, stderr, err := runner.Run("pgbackrest version")
+
+	if err != nil {
+		return "", newError(stdout, stderr, err)
+	}
+
+	return strings.TrimSpace(stdout), nil
+}
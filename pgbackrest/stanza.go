@@ -0,0 +1,75 @@
+package pgbackrest
+
+import (
+	"fmt"
+)
+
+// StanzaCreate creates the given stanza in the repository
+func (ctl Controller) StanzaCreate(stanza string) *Error {
+	return ctl.runStanzaCommand(stanza, "stanza-create")
+}
+
+// StanzaUpgrade updates the stanza after the cluster was upgraded to a new major version
+func (ctl Controller) StanzaUpgrade(stanza string) *Error {
+	return ctl.runStanzaCommand(stanza, "stanza-upgrade")
+}
+
+// Check verifies that the stanza is configured correctly and that WAL archiving works
+func (ctl Controller) Check(stanza string) *Error {
+	return ctl.runStanzaCommand(stanza, "check")
+}
+
+// StanzaDelete removes the stanza and all of its backups and archived WAL from the repository.
+// As a guard against accidents, the confirmation must repeat the stanza name, and the cluster must be stopped.
+func (ctl Controller) StanzaDelete(stanza, confirmation string) *Error {
+	if confirmation != stanza {
+		return &Error{
+			Message: fmt.Sprintf("Refusing to delete stanza %s without confirmation", stanza),
+		}
+	}
+
+	running, err := ctl.cluster.IsRunning()
+
+	if err != nil {
+		return fromPitrError(err)
+	}
+
+	if running {
+		return &Error{
+			Message: fmt.Sprintf("Refusing to delete stanza %s while the cluster is running", stanza),
+			Code:    ErrorCodePgRunning,
+		}
+	}
+
+	stopErr := ctl.Stop(stanza, false)
+
+	if stopErr != nil {
+		return stopErr
+	}
+
+	deleteErr := ctl.runStanzaCommand(stanza, "stanza-delete")
+
+	// Remove <stanza>.stop in any case: if the stanza still exists, pgbackrest may operate on it again;
+	// otherwise, it could not be created anew.
+	startErr := ctl.Start(stanza)
+
+	if deleteErr != nil {
+		if startErr != nil {
+			deleteErr.Message = fmt.Sprintf("%s; starting pgbackrest again failed, too: %s", deleteErr.Message, startErr.Message)
+		}
+
+		return deleteErr
+	}
+
+	return startErr
+}
+
+func (ctl Controller) runStanzaCommand(stanza, command string) *Error {
+	stdout, stderr, err := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s %s", stanza, command)
+
+	if err != nil {
+		return newError(stdout, stderr, err)
+	}
+
+	return nil
+}
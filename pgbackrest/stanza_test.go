@@ -0,0 +1,130 @@
+package pgbackrest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Stanza lifecycle", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "")
+	})
+
+	It("creates a stanza", func() {
+		err := ctl.StanzaCreate("pitr")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr stanza-create"))
+	})
+
+	It("upgrades a stanza", func() {
+		err := ctl.StanzaUpgrade("pitr")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr stanza-upgrade"))
+	})
+
+	It("checks a stanza", func() {
+		err := ctl.Check("pitr")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr check"))
+	})
+
+	Context("archiving does not work", func() {
+		BeforeEach(func() {
+			runner.On("check", "", "ERROR: [082]: WAL segment 000000010000000000000008 was not archived before the 60000ms timeout", h.ExitError{Status: 82})
+		})
+
+		It("provides the error code", func() {
+			err := ctl.Check("pitr")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeArchiveTimeout))
+		})
+	})
+
+	Context("the stanza exists for another cluster", func() {
+		BeforeEach(func() {
+			runner.On("stanza-create", "", "ERROR: [028]: backup and archive info files exist but do not match the database", h.ExitError{Status: 28})
+		})
+
+		It("provides the error code", func() {
+			err := ctl.StanzaCreate("pitr")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeFileInvalid))
+		})
+	})
+
+	Context("deleting a stanza", func() {
+		Context("of a stopped cluster", func() {
+			BeforeEach(func() {
+				runner.On("pg_ctlcluster", "", "", h.ExitError{Status: 3})
+			})
+
+			It("stops pgbackrest before deleting the stanza", func() {
+				err := ctl.StanzaDelete("pitr", "pitr")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.CommandsContaining("pgbackrest")).To(Equal([]string{
+					"sudo --user postgres pgbackrest --stanza=pitr stop",
+					"sudo --user postgres pgbackrest --stanza=pitr stanza-delete",
+					"sudo --user postgres pgbackrest --stanza=pitr start",
+				}))
+			})
+
+			It("requires a confirmation", func() {
+				err := ctl.StanzaDelete("pitr", "yes")
+				Expect(err).To(HaveOccurred())
+				Expect(runner.Commands).To(BeEmpty())
+			})
+
+			Context("deletion fails", func() {
+				BeforeEach(func() {
+					runner.On("stanza-delete", "", "ERROR: [055]: unable to load info file", h.ExitError{Status: 55})
+				})
+
+				It("starts pgbackrest again", func() {
+					err := ctl.StanzaDelete("pitr", "pitr")
+					Expect(err).To(HaveOccurred())
+					Expect(err.Code).To(Equal(pgbackrest.ErrorCodeFileMissing))
+					Expect(runner.CommandsContaining("pgbackrest --stanza=pitr start")).To(HaveLen(1))
+				})
+
+				Context("starting pgbackrest fails, too", func() {
+					BeforeEach(func() {
+						runner.On("--stanza=pitr start", "", "ERROR: [048]: unable to remove stop file", h.ExitError{Status: 48})
+					})
+
+					It("reports both", func() {
+						err := ctl.StanzaDelete("pitr", "pitr")
+						Expect(err).To(HaveOccurred())
+						Expect(err.Code).To(Equal(pgbackrest.ErrorCodeFileMissing))
+						Expect(err.Message).To(ContainSubstring("starting pgbackrest again failed"))
+					})
+				})
+			})
+
+			Context("starting pgbackrest after the deletion fails", func() {
+				BeforeEach(func() {
+					runner.On("--stanza=pitr start", "", "ERROR: [048]: unable to remove stop file", h.ExitError{Status: 48})
+				})
+
+				It("reports the error", func() {
+					err := ctl.StanzaDelete("pitr", "pitr")
+					Expect(err).To(HaveOccurred())
+					Expect(runner.CommandsContaining("stanza-delete")).To(HaveLen(1))
+				})
+			})
+		})
+
+		Context("of a running cluster", func() {
+			It("refuses to delete the stanza", func() {
+				err := ctl.StanzaDelete("pitr", "pitr")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Code).To(Equal(pgbackrest.ErrorCodePgRunning))
+				Expect(runner.CommandsContaining("pgbackrest")).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -0,0 +1,219 @@
+package pgbackrest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VerifyOptions control what `pgbackrest verify` checks. The zero value verifies all backups and archived WAL.
+type VerifyOptions struct {
+	// Set restricts verification to the backup with the given label
+	Set string
+
+	// Repo is the key of the repository to verify; 0 verifies the first repository
+	Repo int
+
+	// Verbose makes pgbackrest report on valid backups and WAL, too
+	Verbose bool
+}
+
+// VerifyReport is the result of `pgbackrest verify`
+type VerifyReport struct {
+	Stanza   string
+	Status   string
+	Archives []VerifiedArchive
+	Backups  []VerifiedBackup
+
+	// Warnings are the problems pgbackrest reported on individual files
+	Warnings []string
+}
+
+// VerifiedArchive is the result of verifying the WAL archived for a PostgreSQL cluster
+type VerifiedArchive struct {
+	ID       string
+	Checked  int
+	Valid    int
+	Problems VerifyProblems
+}
+
+// VerifiedBackup is the result of verifying a backup
+type VerifiedBackup struct {
+	Label    string
+	Status   string
+	Checked  int
+	Valid    int
+	Problems VerifyProblems
+}
+
+// VerifyProblems counts the files that failed verification, by cause
+type VerifyProblems struct {
+	Missing         int
+	ChecksumInvalid int
+	SizeInvalid     int
+	Other           int
+}
+
+var (
+	verifyStanzaPattern   = regexp.MustCompile(`^stanza: (\S+)`)
+	verifyStatusPattern   = regexp.MustCompile(`^status: (\S+)`)
+	verifyEndPattern      = regexp.MustCompile(`verify command end: completed successfully`)
+	verifyArchivePattern  = regexp.MustCompile(`archiveId: ([^,]+), total WAL checked: (\d+), total valid WAL: (\d+)`)
+	verifyBackupPattern   = regexp.MustCompile(`backup: ([^,]+), status: ([^,]+), total files checked: (\d+), total valid files: (\d+)`)
+	verifyProblemsPattern = regexp.MustCompile(`missing: (\d+), checksum invalid: (\d+), size invalid: (\d+), other: (\d+)`)
+	verifyWarningPattern  = regexp.MustCompile(`WARN: (.+)$`)
+	logPrefixPattern      = regexp.MustCompile(`^(?:\S+ \S+ )?P\d+ +\w+: `)
+)
+
+// Verify checks the backups and the archived WAL of the given stanza in the repository. If pgbackrest finds
+// problems, the report is returned together with the error.
+func (ctl Controller) Verify(stanza string, options VerifyOptions) (VerifyReport, *Error) {
+	var args strings.Builder
+
+	if "" != options.Set {
+		args.WriteString(" --set=" + shellQuote(options.Set))
+	}
+
+	if options.Repo > 0 {
+		fmt.Fprintf(&args, " --repo=%d", options.Repo)
+	}
+
+	if options.Verbose {
+		args.WriteString(" --verbose")
+	}
+
+	// logging at info level shows the end of the command, for versions that print no summary
+	stdout, stderr, runErr := ctl.runner.Run("sudo --user postgres pgbackrest --stanza=%s --log-level-console=info --output=text verify%s", stanza, args.String())
+	report := ParseVerifyOutput(stdout + "\n" + stderr)
+
+	if runErr != nil {
+		return report, newError(stdout, stderr, runErr)
+	}
+
+	if "" == report.Status {
+		return report, &Error{
+			Message: fmt.Sprintf("Verification of stanza %s did not report a status", stanza),
+			Stdout:  stdout,
+			Stderr:  stderr,
+			Code:    ErrorCodeFormat,
+		}
+	}
+
+	if !report.OK() {
+		return report, &Error{
+			Message: fmt.Sprintf("Verification of stanza %s found problems", stanza),
+			Stdout:  stdout,
+			Stderr:  stderr,
+			Code:    ErrorCodeFileInvalid,
+		}
+	}
+
+	return report, nil
+}
+
+// ParseVerifyOutput parses the text output of `pgbackrest verify`, including warnings about individual files.
+// The status comes from the summary line; versions without text output have none, but log the successful end
+// of the command instead. Without either, the status stays empty.
+func ParseVerifyOutput(output string) VerifyReport {
+	report := VerifyReport{}
+	var problems *VerifyProblems
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		if match := verifyWarningPattern.FindStringSubmatch(line); match != nil {
+			report.Warnings = append(report.Warnings, match[1])
+			continue
+		}
+
+		line = logPrefixPattern.ReplaceAllString(line, "")
+
+		if match := verifyArchivePattern.FindStringSubmatch(line); match != nil {
+			report.Archives = append(report.Archives, VerifiedArchive{
+				ID:      match[1],
+				Checked: atoi(match[2]),
+				Valid:   atoi(match[3]),
+			})
+
+			problems = &report.Archives[len(report.Archives)-1].Problems
+			continue
+		}
+
+		if match := verifyBackupPattern.FindStringSubmatch(line); match != nil {
+			report.Backups = append(report.Backups, VerifiedBackup{
+				Label:   match[1],
+				Status:  match[2],
+				Checked: atoi(match[3]),
+				Valid:   atoi(match[4]),
+			})
+
+			problems = &report.Backups[len(report.Backups)-1].Problems
+			continue
+		}
+
+		if match := verifyProblemsPattern.FindStringSubmatch(line); match != nil && problems != nil {
+			*problems = VerifyProblems{
+				Missing:         atoi(match[1]),
+				ChecksumInvalid: atoi(match[2]),
+				SizeInvalid:     atoi(match[3]),
+				Other:           atoi(match[4]),
+			}
+
+			problems = nil
+			continue
+		}
+
+		if match := verifyStatusPattern.FindStringSubmatch(line); match != nil {
+			report.Status = match[1]
+			continue
+		}
+
+		if match := verifyStanzaPattern.FindStringSubmatch(line); match != nil {
+			report.Stanza = match[1]
+			continue
+		}
+
+		if verifyEndPattern.MatchString(line) && "" == report.Status {
+			report.Status = "ok"
+		}
+	}
+
+	return report
+}
+
+// OK returns true if pgbackrest reported its status, and no problems were found
+func (report VerifyReport) OK() bool {
+	if "ok" != report.Status {
+		return false
+	}
+
+	if 0 < len(report.Warnings) {
+		return false
+	}
+
+	for _, archive := range report.Archives {
+		if 0 < archive.Problems.Total() {
+			return false
+		}
+	}
+
+	for _, backup := range report.Backups {
+		if "valid" != backup.Status || 0 < backup.Problems.Total() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Total returns the number of files that failed verification
+func (problems VerifyProblems) Total() int {
+	return problems.Missing + problems.ChecksumInvalid + problems.SizeInvalid + problems.Other
+}
+
+// atoi converts digits that were matched by a regular expression
+func atoi(digits string) int {
+	number, _ := strconv.Atoi(digits)
+	return number
+}
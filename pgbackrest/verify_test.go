@@ -0,0 +1,167 @@
+package pgbackrest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+var _ = Describe("Verify", func() {
+	var runner *h.FakeRunner
+	var ctl pgbackrest.Controller
+	var options pgbackrest.VerifyOptions
+	var report pgbackrest.VerifyReport
+	var err *pgbackrest.Error
+
+	BeforeEach(func() {
+		runner, ctl = fakeController("11", "")
+		options = pgbackrest.VerifyOptions{}
+	})
+
+	JustBeforeEach(func() {
+		report, err = ctl.Verify("pitr", options)
+	})
+
+	Context("all is well", func() {
+		BeforeEach(func() {
+			runner.On("verify", readFixture("verify-ok.txt"), "", nil)
+		})
+
+		It("verifies all backups", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --log-level-console=info --output=text verify"))
+		})
+
+		It("has the status", func() {
+			Expect(report.Stanza).To(Equal("pitr"))
+			Expect(report.Status).To(Equal("ok"))
+			Expect(report.OK()).To(BeTrue())
+			Expect(report.Warnings).To(BeEmpty())
+		})
+
+		It("has the archive", func() {
+			Expect(report.Archives).To(Equal([]pgbackrest.VerifiedArchive{{ID: "11-1", Checked: 8, Valid: 8}}))
+		})
+
+		It("has the backups", func() {
+			Expect(report.Backups).To(HaveLen(2))
+			Expect(report.Backups[0]).To(Equal(pgbackrest.VerifiedBackup{Label: "20191126-101005F", Status: "valid", Checked: 964, Valid: 964}))
+			Expect(report.Backups[1].Label).To(Equal("20191126-101005F_20191126-101520D"))
+		})
+	})
+
+	Context("options are set", func() {
+		BeforeEach(func() {
+			options = pgbackrest.VerifyOptions{Set: "20191126-101005F", Repo: 2, Verbose: true}
+		})
+
+		It("passes them on", func() {
+			Expect(runner.Commands).To(ConsistOf("sudo --user postgres pgbackrest --stanza=pitr --log-level-console=info --output=text verify --set='20191126-101005F' --repo=2 --verbose"))
+		})
+	})
+
+	Context("files are corrupt or missing", func() {
+		BeforeEach(func() {
+			runner.On("verify", readFixture("verify-error.txt"), "", nil)
+		})
+
+		It("fails", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(report.OK()).To(BeFalse())
+			Expect(report.Status).To(Equal("error"))
+		})
+
+		It("reports the broken archive", func() {
+			Expect(report.Archives[0].Valid).To(Equal(7))
+			Expect(report.Archives[0].Problems).To(Equal(pgbackrest.VerifyProblems{ChecksumInvalid: 1}))
+		})
+
+		It("reports the broken backup", func() {
+			Expect(report.Backups[0].Status).To(Equal("invalid"))
+			Expect(report.Backups[0].Problems).To(Equal(pgbackrest.VerifyProblems{Missing: 1}))
+			Expect(report.Backups[0].Problems.Total()).To(Equal(1))
+			Expect(report.Backups[1].Problems.Total()).To(BeZero())
+		})
+
+		It("reports the affected files", func() {
+			Expect(report.Warnings).To(Equal([]string{
+				"invalid checksum '11-1/0000000100000000/000000010000000000000004-7a9ef1ac3c1f0c1a7e4c9bd8c1f2ad8f3c4f9c57.gz'",
+				"file missing '20191126-101005F/pg_data/base/16384/2619.gz'",
+			}))
+		})
+	})
+
+	Context("pgbackrest prints nothing", func() {
+		BeforeEach(func() {
+			runner.On("verify", "", "", nil)
+		})
+
+		It("fails", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeFormat))
+			Expect(report.OK()).To(BeFalse())
+		})
+	})
+
+	Context("pgbackrest prints no summary", func() {
+		BeforeEach(func() {
+			runner.On("verify", "  backup: 20191126-101005F, status: valid, total files checked: 964, total valid files: 964\n", "", nil)
+		})
+
+		It("fails", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeFormat))
+		})
+	})
+
+	Context("pgbackrest logs the end of the command, but prints no summary", func() {
+		BeforeEach(func() {
+			runner.On("verify", `2021-04-12 08:20:00.100 P00   INFO: verify command begin 2.33: --log-level-console=info --stanza=pitr
+2021-04-12 08:20:01.200 P00   INFO: archiveId: 13-2, total WAL checked: 5, total valid WAL: 5
+2021-04-12 08:20:01.300 P00   INFO: backup: 20210412-093047F, status: valid, total files checked: 970, total valid files: 970
+2021-04-12 08:20:01.400 P00   INFO: verify command end: completed successfully
+`, "", nil)
+		})
+
+		It("takes the successful end as the status", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Status).To(Equal("ok"))
+			Expect(report.Backups).To(HaveLen(1))
+		})
+	})
+
+	Context("pgbackrest fails", func() {
+		BeforeEach(func() {
+			runner.On("verify", "", "ERROR: [055]: unable to load info file '/var/lib/pgbackrest/backup/pitr/backup.info'", h.ExitError{Status: 55})
+		})
+
+		It("provides the error code", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Code).To(Equal(pgbackrest.ErrorCodeFileMissing))
+		})
+	})
+})
+
+var _ = Describe("verify output parser", func() {
+	It("understands the log of pgbackrest versions without text output", func() {
+		report := pgbackrest.ParseVerifyOutput(`2021-04-12 08:20:00.100 P00   INFO: verify command begin 2.33: --stanza=pitr
+2021-04-12 08:20:01.200 P00   INFO: archiveId: 13-2, total WAL checked: 5, total valid WAL: 5
+2021-04-12 08:20:01.300 P00   INFO: backup: 20210412-093047F, status: valid, total files checked: 970, total valid files: 970
+2021-04-12 08:20:01.400 P00   INFO: verify command end: completed successfully`)
+
+		Expect(report.OK()).To(BeTrue())
+		Expect(report.Archives).To(HaveLen(1))
+		Expect(report.Archives[0].ID).To(Equal("13-2"))
+		Expect(report.Backups).To(HaveLen(1))
+		Expect(report.Backups[0].Checked).To(Equal(970))
+	})
+
+	It("does not take a log without the end of the command as success", func() {
+		report := pgbackrest.ParseVerifyOutput(`2021-04-12 08:20:00.100 P00   INFO: verify command begin 2.33: --stanza=pitr
+2021-04-12 08:20:01.200 P00   INFO: archiveId: 13-2, total WAL checked: 5, total valid WAL: 5`)
+
+		Expect(report.Status).To(BeEmpty())
+		Expect(report.OK()).To(BeFalse())
+	})
+})
@@ -5,6 +5,9 @@ import "fmt"
 // Runner executes commands
 type Runner interface {
 	Run(command string, args ...interface{}) (string, string, error)
+
+	// RunWithInput passes input to the command on stdin, keeping it out of the process list
+	RunWithInput(input, command string, args ...interface{}) (string, string, error)
 }
 
 // Error encapsulates information about failing run
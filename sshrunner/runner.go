@@ -3,7 +3,9 @@ package sshrunner
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"strings"
 
 	"github.com/mikkeloscar/sshconfig"
 	"golang.org/x/crypto/ssh"
@@ -49,6 +51,15 @@ func (runner *Runner) New(host sshconfig.SSHHost) (*Runner, error) {
 
 // Run executes the given command via SSH, with args interpolated.
 func (runner *Runner) Run(command string, args ...interface{}) (string, string, error) {
+	return runner.run(nil, command, args...)
+}
+
+// RunWithInput executes the given command via SSH, with args interpolated, and passes input on stdin.
+func (runner *Runner) RunWithInput(input, command string, args ...interface{}) (string, string, error) {
+	return runner.run(strings.NewReader(input), command, args...)
+}
+
+func (runner *Runner) run(stdin io.Reader, command string, args ...interface{}) (string, string, error) {
 	session, err := runner.client.NewSession()
 
 	if err != nil {
@@ -60,6 +71,7 @@ func (runner *Runner) Run(command string, args ...interface{}) (string, string,
 	var stdoutBuf, stderrBuf bytes.Buffer
 	session.Stdout = &stdoutBuf
 	session.Stderr = &stderrBuf
+	session.Stdin = stdin
 
 	err = session.Run(fmt.Sprintf(command, args...))
 
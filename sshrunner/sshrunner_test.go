@@ -32,6 +32,12 @@ var _ = Describe("SSH Runner", func() {
 		Expect(stdout).To(ContainSubstring("total"))
 	})
 
+	It("passes input on stdin", func() {
+		stdout, stderr, err := ssh.RunWithInput("it's secret\n", "cat")
+		Expect(err).ToNot(HaveOccurred(), "stderr was: '%v', stdout was: '%v'", stderr, stdout)
+		Expect(stdout).To(Equal("it's secret\n"))
+	})
+
 	It("provides error output", func() {
 		stdout, stderr, err := ssh.Run("psql")
 		Expect(err).To(HaveOccurred())
@@ -0,0 +1,258 @@
+package switchover
+
+import (
+	"fmt"
+	"time"
+
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+)
+
+// DefaultMaxLag is the number of bytes the standby may lag behind unless MaxLag is changed. Small enough
+// for the standby to catch up quickly once the primary is stopped.
+const DefaultMaxLag = 1024 * 1024
+
+// Controller swaps the roles of a primary and its standby
+type Controller struct {
+	Primary cluster.Controller
+	Standby cluster.Controller
+
+	// MaxLag is the number of bytes the standby may lag behind before the switchover starts
+	MaxLag int64
+
+	// StandbyName identifies the standby among the replicas of the primary, by its application_name or client address
+	StandbyName string
+
+	// Timeout limits how long to wait for promotion and for the old primary to follow
+	Timeout time.Duration
+
+	// PollInterval is the time between two checks while waiting
+	PollInterval time.Duration
+}
+
+// NewController creates a new controller that is able to turn the given standby into the primary, and vice versa.
+// MaxLag defaults to DefaultMaxLag. StandbyName defaults to the application_name the WAL receiver of the standby
+// uses unless primary_conninfo sets one: 'walreceiver' before PostgreSQL 12, the cluster_name '<version>/<name>'
+// that pg_createcluster configures from then on.
+func NewController(primary, standby cluster.Controller) Controller {
+	return Controller{
+		Primary:      primary,
+		Standby:      standby,
+		MaxLag:       DefaultMaxLag,
+		StandbyName:  defaultStandbyName(standby),
+		Timeout:      time.Minute,
+		PollInterval: time.Second,
+	}
+}
+
+func defaultStandbyName(standby cluster.Controller) string {
+	version, err := pitr.ParseVersion(standby.Version)
+
+	if err == nil && version.Compare(pitr.FirstVersionWithClusterNameAsApplicationName) >= 0 {
+		return fmt.Sprintf("%s/%s", standby.Version, standby.Name)
+	}
+
+	return "walreceiver"
+}
+
+// Switchover makes the standby the new primary, and reconfigures the old primary as its standby.
+// The old primary connects to the new one using the given connection string.
+// If a precondition fails before the standby is promoted, the old primary is left running or restarted.
+// Once the standby is promoted, a controller with swapped roles is returned.
+func (ctl Controller) Switchover(newPrimaryConnInfo string) (Controller, *pitr.Error) {
+	err := ctl.checkPreconditions()
+
+	if err != nil {
+		return ctl, err
+	}
+
+	err = ctl.Primary.Stop()
+
+	if err != nil {
+		return ctl, err
+	}
+
+	err = ctl.checkStandbyReceivedAll()
+
+	if err != nil {
+		return ctl, ctl.abort(err)
+	}
+
+	err = ctl.Standby.Promote()
+
+	if err != nil {
+		return ctl, ctl.abort(err)
+	}
+
+	// From here on, restarting the old primary risks having two primaries
+	err = ctl.waitForPromotion()
+
+	if err != nil {
+		return ctl, err
+	}
+
+	err = ctl.Primary.ConfigureStandby(
+		fmt.Sprintf("primary_conninfo = '%s'", newPrimaryConnInfo),
+		"recovery_target_timeline = 'latest'",
+	)
+
+	if err != nil {
+		return ctl, err
+	}
+
+	err = ctl.Primary.Start()
+
+	if err != nil {
+		return ctl, err
+	}
+
+	swapped := ctl
+	swapped.Primary, swapped.Standby = ctl.Standby, ctl.Primary
+
+	err = swapped.verify()
+
+	if err != nil {
+		return swapped, err
+	}
+
+	return swapped, nil
+}
+
+func (ctl Controller) checkPreconditions() *pitr.Error {
+	inRecovery, err := ctl.Primary.IsInRecovery()
+
+	if err != nil {
+		return err
+	}
+
+	if inRecovery {
+		return &pitr.Error{Message: "The primary is in recovery"}
+	}
+
+	inRecovery, err = ctl.Standby.IsInRecovery()
+
+	if err != nil {
+		return err
+	}
+
+	if !inRecovery {
+		return &pitr.Error{Message: "The standby is not in recovery"}
+	}
+
+	status, err := ctl.Standby.WALReceiverStatus()
+
+	if err != nil {
+		return err
+	}
+
+	if "streaming" != status {
+		return &pitr.Error{Message: fmt.Sprintf("The standby is not streaming from the primary; WAL receiver status is '%s'", status)}
+	}
+
+	replicas, err := ctl.Primary.Replicas()
+
+	if err != nil {
+		return err
+	}
+
+	for _, replica := range replicas {
+		if ctl.StandbyName != replica.ApplicationName && ctl.StandbyName != replica.ClientAddress {
+			continue
+		}
+
+		if "streaming" != replica.State {
+			return &pitr.Error{Message: fmt.Sprintf("The standby %s is not streaming; its state is '%s'", ctl.StandbyName, replica.State)}
+		}
+
+		if replica.Lag < 0 || replica.Lag > ctl.MaxLag {
+			return &pitr.Error{Message: fmt.Sprintf("The standby %s is not within %d bytes of the primary; its lag is %d", ctl.StandbyName, ctl.MaxLag, replica.Lag)}
+		}
+
+		return nil
+	}
+
+	return &pitr.Error{Message: fmt.Sprintf("The standby %s is not among the replicas of the primary: %+v", ctl.StandbyName, replicas)}
+}
+
+func (ctl Controller) checkStandbyReceivedAll() *pitr.Error {
+	controlData, err := ctl.Primary.ControlData()
+
+	if err != nil {
+		return err
+	}
+
+	if "shut down" != controlData["Database cluster state"] {
+		return &pitr.Error{Message: fmt.Sprintf("The primary was not shut down cleanly; state is '%s'", controlData["Database cluster state"])}
+	}
+
+	checkpoint := controlData["Latest checkpoint location"]
+
+	if "" == checkpoint {
+		return &pitr.Error{Message: "Could not determine the final checkpoint of the primary"}
+	}
+
+	return ctl.waitFor(func() (bool, *pitr.Error) {
+		return ctl.Standby.HasReceivedWALPast(checkpoint)
+	}, fmt.Sprintf("The standby did not receive the shutdown checkpoint at %s", checkpoint))
+}
+
+func (ctl Controller) waitForPromotion() *pitr.Error {
+	return ctl.waitFor(func() (bool, *pitr.Error) {
+		inRecovery, err := ctl.Standby.IsInRecovery()
+		return !inRecovery, err
+	}, "The standby did not finish its promotion")
+}
+
+func (ctl Controller) verify() *pitr.Error {
+	inRecovery, err := ctl.Primary.IsInRecovery()
+
+	if err != nil {
+		return err
+	}
+
+	if inRecovery {
+		return &pitr.Error{Message: "The new primary is still in recovery"}
+	}
+
+	return ctl.waitFor(func() (bool, *pitr.Error) {
+		status, err := ctl.Standby.WALReceiverStatus()
+		return "streaming" == status, err
+	}, "The new standby does not stream from the new primary")
+}
+
+// abort restarts the old primary after a failed switchover and returns the original error
+func (ctl Controller) abort(cause *pitr.Error) *pitr.Error {
+	err := ctl.Primary.Start()
+
+	if err != nil {
+		return &pitr.Error{
+			Message: fmt.Sprintf("%s; restarting the primary failed, too: %s", cause.Message, err.Message),
+			Stdout:  err.Stdout,
+			Stderr:  err.Stderr,
+		}
+	}
+
+	return cause
+}
+
+func (ctl Controller) waitFor(condition func() (bool, *pitr.Error), message string) *pitr.Error {
+	deadline := time.Now().Add(ctl.Timeout)
+
+	for {
+		done, err := condition()
+
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return &pitr.Error{Message: message}
+		}
+
+		time.Sleep(ctl.PollInterval)
+	}
+}
@@ -0,0 +1,13 @@
+package switchover_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSwitchover(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Switchover Suite")
+}
@@ -0,0 +1,189 @@
+package switchover_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/switchover"
+)
+
+const controlData = `pg_control version number:            1100
+Database cluster state:               shut down
+Latest checkpoint location:           0/5000028
+`
+
+var _ = Describe("Switchover", func() {
+	var primaryRunner, standbyRunner *h.FakeRunner
+	var ctl switchover.Controller
+	var swapped switchover.Controller
+	var err *pitr.Error
+
+	BeforeEach(func() {
+		primaryRunner = &h.FakeRunner{}
+		primaryRunner.On("select pg_is_in_recovery()", "t\n", "", nil)
+		primaryRunner.Once("select pg_is_in_recovery()", "f\n", "", nil)
+		primaryRunner.On("from pg_stat_replication", "walreceiver|192.168.71.30|streaming|0\n", "", nil)
+		primaryRunner.On("pg_controldata", controlData, "", nil)
+		primaryRunner.On("PG_VERSION", "11\n", "", nil)
+		primaryRunner.On("from pg_stat_wal_receiver", "streaming\n", "", nil)
+
+		standbyRunner = &h.FakeRunner{}
+		standbyRunner.On("select pg_is_in_recovery()", "f\n", "", nil)
+		standbyRunner.Once("select pg_is_in_recovery()", "t\n", "", nil)
+		standbyRunner.On("from pg_stat_wal_receiver", "streaming\n", "", nil)
+		standbyRunner.On("pg_last_wal_receive_lsn()", "t\n", "", nil)
+
+		ctl = switchover.NewController(
+			cluster.NewController(primaryRunner, "11", "main"),
+			cluster.NewController(standbyRunner, "11", "main"),
+		)
+		ctl.Timeout = 0
+		ctl.PollInterval = 0
+	})
+
+	JustBeforeEach(func() {
+		swapped, err = ctl.Switchover("host=192.168.71.30 user=replicator")
+	})
+
+	Context("replication is caught up", func() {
+		It("succeeds", func() {
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("stops the primary", func() {
+			Expect(primaryRunner.CommandsContaining("pg_ctlcluster 11 main stop")).To(HaveLen(1))
+		})
+
+		It("promotes the standby", func() {
+			Expect(standbyRunner.CommandsContaining("pg_ctlcluster 11 main promote")).To(HaveLen(1))
+		})
+
+		It("makes the old primary follow the new one", func() {
+			Expect(primaryRunner.CommandsContaining("primary_conninfo = 'host=192.168.71.30 user=replicator'")).To(HaveLen(1))
+			Expect(primaryRunner.CommandsContaining("pg_ctlcluster 11 main start")).To(HaveLen(1))
+		})
+
+		It("swaps the roles", func() {
+			Expect(swapped.Primary).To(Equal(ctl.Standby))
+			Expect(swapped.Standby).To(Equal(ctl.Primary))
+		})
+	})
+
+	Context("the old primary runs PostgreSQL 12 or later", func() {
+		BeforeEach(func() {
+			primaryRunner.On("PG_VERSION", "12\n", "", nil)
+		})
+
+		It("configures the old primary as standby using a signal file", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(primaryRunner.CommandsContaining("recovery.conf")).To(BeEmpty())
+			Expect(primaryRunner.CommandsContaining("tee --append /var/lib/postgresql/11/main/postgresql.auto.conf")).To(HaveLen(1))
+			Expect(primaryRunner.CommandsContaining("/var/lib/postgresql/11/main/standby.signal")).To(HaveLen(1))
+		})
+
+		It("removes a leftover recovery.signal", func() {
+			Expect(primaryRunner.CommandsContaining("recovery.signal")).To(ConsistOf("sudo --user postgres rm --force /var/lib/postgresql/11/main/recovery.signal"))
+		})
+	})
+
+	Context("the standby lags behind", func() {
+		BeforeEach(func() {
+			primaryRunner.On("from pg_stat_replication", "walreceiver|192.168.71.30|streaming|16777216\n", "", nil)
+		})
+
+		It("aborts without touching the primary", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(primaryRunner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+			Expect(standbyRunner.CommandsContaining("promote")).To(BeEmpty())
+		})
+	})
+
+	Context("only another replica is caught up", func() {
+		BeforeEach(func() {
+			primaryRunner.On("from pg_stat_replication", "reporting|192.168.71.40|streaming|0\nwalreceiver|192.168.71.30|streaming|16777216\n", "", nil)
+		})
+
+		It("aborts without touching the primary", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Message).To(ContainSubstring("walreceiver"))
+			Expect(primaryRunner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+		})
+	})
+
+	Context("the standby is identified by its address", func() {
+		BeforeEach(func() {
+			primaryRunner.On("from pg_stat_replication", "reporting|192.168.71.40|streaming|16777216\nsandbox|192.168.71.30|streaming|0\n", "", nil)
+			ctl.StandbyName = "192.168.71.30"
+		})
+
+		It("succeeds", func() {
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("the standby is not connected to the primary", func() {
+		BeforeEach(func() {
+			primaryRunner.On("from pg_stat_replication", "reporting|192.168.71.40|streaming|0\n", "", nil)
+		})
+
+		It("aborts without touching the primary", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(primaryRunner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+		})
+	})
+
+	Context("the standby is not streaming", func() {
+		BeforeEach(func() {
+			standbyRunner.On("from pg_stat_wal_receiver", "", "", nil)
+		})
+
+		It("aborts without touching the primary", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(primaryRunner.CommandsContaining("pg_ctlcluster")).To(BeEmpty())
+		})
+	})
+
+	Context("the standby did not receive the shutdown checkpoint", func() {
+		BeforeEach(func() {
+			standbyRunner.On("pg_last_wal_receive_lsn()", "f\n", "", nil)
+		})
+
+		It("restarts the old primary", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Message).To(ContainSubstring("0/5000028"))
+			Expect(primaryRunner.CommandsContaining("pg_ctlcluster 11 main start")).To(HaveLen(1))
+			Expect(standbyRunner.CommandsContaining("promote")).To(BeEmpty())
+		})
+	})
+
+	Context("promoting the standby fails", func() {
+		BeforeEach(func() {
+			standbyRunner.On("promote", "", "pg_ctl: server is not in standby mode", h.ExitError{Status: 1})
+		})
+
+		It("restarts the old primary", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(primaryRunner.CommandsContaining("pg_ctlcluster 11 main start")).To(HaveLen(1))
+			Expect(primaryRunner.CommandsContaining("recovery.conf")).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Switchover defaults", func() {
+	It("tolerates a small lag", func() {
+		ctl := switchover.NewController(cluster.NewController(nil, "11", "main"), cluster.NewController(nil, "11", "main"))
+		Expect(ctl.MaxLag).To(Equal(int64(switchover.DefaultMaxLag)))
+	})
+
+	It("looks for the default application name of the WAL receiver", func() {
+		ctl := switchover.NewController(cluster.NewController(nil, "11", "main"), cluster.NewController(nil, "11", "main"))
+		Expect(ctl.StandbyName).To(Equal("walreceiver"))
+	})
+
+	It("looks for the cluster name from PostgreSQL 12 on", func() {
+		ctl := switchover.NewController(cluster.NewController(nil, "13", "main"), cluster.NewController(nil, "13", "standby"))
+		Expect(ctl.StandbyName).To(Equal("13/standby"))
+	})
+})
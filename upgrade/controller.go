@@ -0,0 +1,113 @@
+package upgrade
+
+import (
+	"fmt"
+
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+	"github.com/suhlig/postgres-pitr/pgbackrest"
+)
+
+// Controller upgrades a cluster to a new major version, taking full backups with pgbackrest before and after
+type Controller struct {
+	runner  pitr.Runner
+	cluster cluster.Controller
+	stanza  string
+}
+
+// NewController creates a new controller for upgrading the given cluster, which is backed up into the given stanza.
+// All actions will be performed using the passed Runner.
+func NewController(runner pitr.Runner, cluster cluster.Controller, stanza string) Controller {
+	return Controller{
+		runner:  runner,
+		cluster: cluster,
+		stanza:  stanza,
+	}
+}
+
+// Upgrade upgrades the cluster to the given major version using pg_upgradecluster and returns a controller for the upgraded cluster.
+// The old cluster is kept (stopped) so that it can be dropped with pg_dropcluster once the new one proved to work.
+func (ctl Controller) Upgrade(target pitr.Version) (*cluster.Controller, *pitr.Error) {
+	current, parseErr := pitr.ParseVersion(ctl.cluster.Version)
+
+	if parseErr != nil {
+		return nil, &pitr.Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	if target.Major().Compare(current.Major()) <= 0 {
+		return nil, &pitr.Error{
+			Message: fmt.Sprintf("Cannot upgrade from version %s to %s; the target must be a newer major version", current.MajorString(), target.MajorString()),
+		}
+	}
+
+	stdout, stderr, err := ctl.runner.Run("test -x /usr/lib/postgresql/%s/bin/postgres", target.MajorString())
+
+	if err != nil {
+		return nil, &pitr.Error{
+			Message: fmt.Sprintf("PostgreSQL %s is not installed", target.MajorString()),
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	before := pgbackrest.NewController(ctl.runner, ctl.cluster)
+	backrestErr := before.ValidateVersion(ctl.stanza)
+
+	if backrestErr != nil {
+		return nil, backrestErr.PitrError()
+	}
+
+	backrestErr = before.FullBackup(ctl.stanza)
+
+	if backrestErr != nil {
+		return nil, backrestErr.PitrError()
+	}
+
+	stdout, stderr, err = ctl.runner.Run("sudo pg_upgradecluster --method=upgrade -v %s %s %s", target.MajorString(), ctl.cluster.Version, ctl.cluster.Name)
+
+	if err != nil {
+		return nil, &pitr.Error{
+			Message: "Could not upgrade the cluster",
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+	}
+
+	upgraded := cluster.NewController(ctl.runner, target.MajorString(), ctl.cluster.Name)
+
+	after := pgbackrest.NewController(ctl.runner, upgraded)
+	conf, backrestErr := after.DeployedConf()
+
+	if backrestErr != nil {
+		return nil, backrestErr.PitrError()
+	}
+
+	conf.Set(ctl.stanza, "pg1-path", upgraded.DataDirectory())
+	backrestErr = after.PushConf(conf)
+
+	if backrestErr != nil {
+		return nil, backrestErr.PitrError()
+	}
+
+	backrestErr = after.StanzaUpgrade(ctl.stanza)
+
+	if backrestErr != nil {
+		return nil, backrestErr.PitrError()
+	}
+
+	backrestErr = after.ValidateVersion(ctl.stanza)
+
+	if backrestErr != nil {
+		return nil, backrestErr.PitrError()
+	}
+
+	backrestErr = after.FullBackup(ctl.stanza)
+
+	if backrestErr != nil {
+		return nil, backrestErr.PitrError()
+	}
+
+	return &upgraded, nil
+}
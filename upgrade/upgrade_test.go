@@ -0,0 +1,125 @@
+package upgrade_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/upgrade"
+)
+
+const infoBefore = `[{"name":"pitr","status":{"code":0,"message":"ok"},
+	"db":[{"id":1,"system-id":6645462845638447489,"version":"11"}],
+	"backup":[{"label":"20190121-093614F","type":"full","database":{"id":1}}]}]`
+
+const infoBackedUp = `[{"name":"pitr","status":{"code":0,"message":"ok"},
+	"db":[{"id":1,"system-id":6645462845638447489,"version":"11"}],
+	"backup":[{"label":"20190121-093614F","type":"full","database":{"id":1}},{"label":"20190121-100940F","type":"full","database":{"id":1}}]}]`
+
+const infoUpgraded = `[{"name":"pitr","status":{"code":0,"message":"ok"},
+	"db":[{"id":1,"system-id":6645462845638447489,"version":"11"},{"id":2,"system-id":6645470987123456789,"version":"12"}],
+	"backup":[{"label":"20190121-093614F","type":"full","database":{"id":1}},{"label":"20190121-100940F","type":"full","database":{"id":1}}]}]`
+
+const infoAfter = `[{"name":"pitr","status":{"code":0,"message":"ok"},
+	"db":[{"id":1,"system-id":6645462845638447489,"version":"11"},{"id":2,"system-id":6645470987123456789,"version":"12"}],
+	"backup":[{"label":"20190121-093614F","type":"full","database":{"id":1}},{"label":"20190121-100940F","type":"full","database":{"id":1}},{"label":"20190121-101532F","type":"full","database":{"id":2}}]}]`
+
+var _ = Describe("Upgrade", func() {
+	var runner *h.FakeRunner
+	var ctl upgrade.Controller
+	var target pitr.Version
+	var upgraded *cluster.Controller
+	var err *pitr.Error
+
+	BeforeEach(func() {
+		runner = &h.FakeRunner{}
+		// latest registration first: validate and back up before the upgrade, then after it
+		runner.On("pgbackrest info", infoAfter, "", nil)
+		runner.Once("pgbackrest info", infoUpgraded, "", nil)
+		runner.Once("pgbackrest info", infoUpgraded, "", nil)
+		runner.Once("pgbackrest info", infoBackedUp, "", nil)
+		runner.Once("pgbackrest info", infoBefore, "", nil)
+		runner.Once("pgbackrest info", infoBefore, "", nil)
+		runner.On("cat /etc/pgbackrest.conf", "[pitr]\npg1-path=/var/lib/postgresql/11/main\n\n[global]\nrepo1-path=/var/lib/pgbackrest\n", "", nil)
+
+		ctl = upgrade.NewController(runner, cluster.NewController(runner, "11", "main"), "pitr")
+		target = pitr.Version{Num: 120000}
+	})
+
+	JustBeforeEach(func() {
+		upgraded, err = ctl.Upgrade(target)
+	})
+
+	It("succeeds", func() {
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("returns the upgraded cluster", func() {
+		Expect(upgraded.Version).To(Equal("12"))
+		Expect(upgraded.Name).To(Equal("main"))
+	})
+
+	It("performs the steps in order", func() {
+		Expect(runner.Commands).To(HaveLen(13))
+		Expect(runner.Commands[0]).To(ContainSubstring("/usr/lib/postgresql/12/bin/postgres"))
+		Expect(runner.Commands[1]).To(ContainSubstring("pgbackrest info"))
+		Expect(runner.Commands[2]).To(ContainSubstring("pgbackrest info"))
+		Expect(runner.Commands[3]).To(ContainSubstring("backup --type=full"))
+		Expect(runner.Commands[4]).To(ContainSubstring("pgbackrest info"))
+		Expect(runner.Commands[5]).To(ContainSubstring("pg_upgradecluster --method=upgrade -v 12 11 main"))
+		Expect(runner.Commands[6]).To(Equal("cat /etc/pgbackrest.conf"))
+		Expect(runner.Commands[7]).To(Equal("sudo --user postgres tee /etc/pgbackrest.conf > /dev/null"))
+		Expect(runner.Inputs[7]).To(Equal("[pitr]\npg1-path=/var/lib/postgresql/12/main\n\n[global]\nrepo1-path=/var/lib/pgbackrest\n"))
+		Expect(runner.Commands[8]).To(ContainSubstring("stanza-upgrade"))
+		Expect(runner.Commands[9]).To(ContainSubstring("pgbackrest info"))
+		Expect(runner.Commands[10]).To(ContainSubstring("pgbackrest info"))
+		Expect(runner.Commands[11]).To(ContainSubstring("backup --type=full"))
+		Expect(runner.Commands[12]).To(ContainSubstring("pgbackrest info"))
+	})
+
+	Context("the target version is not newer", func() {
+		BeforeEach(func() {
+			target = pitr.Version{Num: 110005}
+		})
+
+		It("refuses to upgrade", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.Commands).To(BeEmpty())
+		})
+	})
+
+	Context("the target version is not installed", func() {
+		BeforeEach(func() {
+			runner.On("bin/postgres", "", "", h.ExitError{Status: 1})
+		})
+
+		It("does not start the upgrade", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_upgradecluster")).To(BeEmpty())
+		})
+	})
+
+	Context("the backups do not match the cluster version", func() {
+		BeforeEach(func() {
+			runner.On("pgbackrest info", `[{"name":"pitr","status":{"code":0,"message":"ok"},"db":[{"id":1,"system-id":1,"version":"10"}],"backup":[{"label":"20190121-093614F"}]}]`, "", nil)
+		})
+
+		It("does not start the upgrade", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Message).To(ContainSubstring("version 10"))
+			Expect(runner.CommandsContaining("pg_upgradecluster")).To(BeEmpty())
+		})
+	})
+
+	Context("the backup before the upgrade fails", func() {
+		BeforeEach(func() {
+			runner.On("backup --type=full", "", "ERROR: [056]: unable to find primary cluster", h.ExitError{Status: 56})
+		})
+
+		It("does not start the upgrade", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.CommandsContaining("pg_upgradecluster")).To(BeEmpty())
+		})
+	})
+})
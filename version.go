@@ -0,0 +1,127 @@
+package postgres_pitr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a PostgreSQL server version like 9.6.24 or 11.2.
+// Num follows the format of server_version_num, e.g. 90624 or 110002.
+type Version struct {
+	Num int
+}
+
+// The first PostgreSQL versions with features that depend on the version
+var (
+	// FirstVersionWithPgWal names the WAL directory pg_wal instead of pg_xlog, and xlog functions wal functions
+	FirstVersionWithPgWal = Version{Num: 100000}
+
+	// FirstVersionWithLSNTarget supports recovery_target_lsn
+	FirstVersionWithLSNTarget = Version{Num: 100000}
+
+	// FirstVersionWithHotStandbyOn accepts read-only queries during recovery by default
+	FirstVersionWithHotStandbyOn = Version{Num: 100000}
+
+	// FirstVersionWithOfflineChecksums verifies the checksums of a stopped cluster, with pg_verify_checksums
+	FirstVersionWithOfflineChecksums = Version{Num: 110000}
+
+	// FirstVersionWithPgChecksums renamed pg_verify_checksums to pg_checksums
+	FirstVersionWithPgChecksums = Version{Num: 120000}
+
+	// FirstVersionWithChecksumFailureStats counts checksum failures in pg_stat_database
+	FirstVersionWithChecksumFailureStats = Version{Num: 120000}
+
+	// FirstVersionWithSignalFiles dropped recovery.conf in favor of signal files
+	FirstVersionWithSignalFiles = Version{Num: 120000}
+
+	// FirstVersionWithArchiveModeOff is the first version for which pgbackrest can disable archiving on restore
+	FirstVersionWithArchiveModeOff = Version{Num: 120000}
+
+	// FirstVersionWithClusterNameAsApplicationName uses cluster_name as the default application_name of a WAL receiver
+	FirstVersionWithClusterNameAsApplicationName = Version{Num: 120000}
+)
+
+// ParseVersion parses a version like "9.6", "9.6.24", "11" or "11.2 (Ubuntu 11.2-1.pgdg18.04+1)"
+func ParseVersion(version string) (Version, error) {
+	fields := strings.Fields(version)
+
+	if 0 == len(fields) {
+		return Version{}, fmt.Errorf("Error parsing empty version")
+	}
+
+	parts := strings.Split(fields[0], ".")
+	numbers := make([]int, len(parts))
+
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+
+		if err != nil || number < 0 {
+			return Version{}, fmt.Errorf("Error parsing version '%s'", version)
+		}
+
+		numbers[i] = number
+	}
+
+	if numbers[0] >= 10 {
+		if len(numbers) > 2 {
+			return Version{}, fmt.Errorf("Error parsing version '%s'; expected at most 2 parts, but found %d", version, len(numbers))
+		}
+
+		numbers = append(numbers, 0)
+
+		return Version{Num: numbers[0]*10000 + numbers[1]}, nil
+	}
+
+	if len(numbers) < 2 || len(numbers) > 3 {
+		return Version{}, fmt.Errorf("Error parsing version '%s'; expected 2 or 3 parts, but found %d", version, len(numbers))
+	}
+
+	numbers = append(numbers, 0)
+
+	return Version{Num: numbers[0]*10000 + numbers[1]*100 + numbers[2]}, nil
+}
+
+// Major returns the major version without the minor release, e.g. 9.6 for 9.6.24 or 11 for 11.2
+func (v Version) Major() Version {
+	if v.Num < 100000 {
+		return Version{Num: v.Num / 100 * 100}
+	}
+
+	return Version{Num: v.Num / 10000 * 10000}
+}
+
+// MajorString formats the major version as used for cluster and directory names, e.g. "9.6" or "11"
+func (v Version) MajorString() string {
+	if v.Num < 100000 {
+		return fmt.Sprintf("%d.%d", v.Num/10000, v.Num/100%100)
+	}
+
+	return strconv.Itoa(v.Num / 10000)
+}
+
+// String formats the full version, e.g. "9.6.24" or "11.2"
+func (v Version) String() string {
+	if v.Num < 100000 {
+		return fmt.Sprintf("%s.%d", v.MajorString(), v.Num%100)
+	}
+
+	return fmt.Sprintf("%s.%d", v.MajorString(), v.Num%10000)
+}
+
+// Compare returns -1 if v is older than other, 1 if it is newer, and 0 if both are the same
+func (v Version) Compare(other Version) int {
+	switch {
+	case v.Num < other.Num:
+		return -1
+	case v.Num > other.Num:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// SameMajor returns true if both versions belong to the same major version, and are thus binary compatible
+func (v Version) SameMajor(other Version) bool {
+	return v.Major() == other.Major()
+}
@@ -0,0 +1,88 @@
+package postgres_pitr_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	pitr "github.com/suhlig/postgres-pitr"
+)
+
+var _ = Describe("Version", func() {
+	parse := func(s string) pitr.Version {
+		version, err := pitr.ParseVersion(s)
+		Expect(err).NotTo(HaveOccurred())
+		return version
+	}
+
+	Context("before 10", func() {
+		It("parses the major version", func() {
+			Expect(parse("9.6").Num).To(Equal(90600))
+		})
+
+		It("parses a minor release", func() {
+			Expect(parse("9.6.24").Num).To(Equal(90624))
+		})
+
+		It("formats the major version", func() {
+			Expect(parse("9.6.24").MajorString()).To(Equal("9.6"))
+		})
+
+		It("formats the full version", func() {
+			Expect(parse("9.6.24").String()).To(Equal("9.6.24"))
+		})
+
+		It("is not complete without the second part", func() {
+			_, err := pitr.ParseVersion("9")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("10 and later", func() {
+		It("parses the major version", func() {
+			Expect(parse("11").Num).To(Equal(110000))
+		})
+
+		It("parses a minor release", func() {
+			Expect(parse("11.2").Num).To(Equal(110002))
+		})
+
+		It("ignores distribution details", func() {
+			Expect(parse("11.2 (Ubuntu 11.2-1.pgdg18.04+1)").Num).To(Equal(110002))
+		})
+
+		It("formats the major version", func() {
+			Expect(parse("11.2").MajorString()).To(Equal("11"))
+		})
+
+		It("formats the full version", func() {
+			Expect(parse("11.2").String()).To(Equal("11.2"))
+		})
+
+		It("has only two parts", func() {
+			_, err := pitr.ParseVersion("11.2.1")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("does not parse garbage", func() {
+		_, err := pitr.ParseVersion("eleven")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not parse an empty string", func() {
+		_, err := pitr.ParseVersion("")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("compares versions", func() {
+		Expect(parse("9.6.24").Compare(parse("10.1"))).To(Equal(-1))
+		Expect(parse("12").Compare(parse("11.9"))).To(Equal(1))
+		Expect(parse("11.2").Compare(parse("11.2"))).To(Equal(0))
+	})
+
+	It("knows about the major version", func() {
+		Expect(parse("9.6.24").SameMajor(parse("9.6.1"))).To(BeTrue())
+		Expect(parse("9.6.24").SameMajor(parse("9.5.24"))).To(BeFalse())
+		Expect(parse("11.2").SameMajor(parse("11"))).To(BeTrue())
+		Expect(parse("11.2").SameMajor(parse("12.2"))).To(BeFalse())
+	})
+})
@@ -8,6 +8,8 @@ import (
 	"github.com/suhlig/postgres-pitr/cluster"
 )
 
+const restoreCommand = `restore_command = 'bash --login -c \"wal-g wal-fetch %f %p\"'`
+
 // Controller provides a way to control WAL-G
 type Controller struct {
 	runner  pitr.Runner
@@ -79,7 +81,7 @@ func (ctl Controller) Restore(name string) *pitr.Error {
 		}
 	}
 
-	ctl.createRecoveryConf(`restore_command = 'bash --login -c \"wal-g wal-fetch %f %p\"'`)
+	err = ctl.cluster.ConfigureRecovery(restoreCommand)
 
 	if err != nil {
 		return err
@@ -94,8 +96,32 @@ func (ctl Controller) Restore(name string) *pitr.Error {
 	return nil
 }
 
-// RestoreToTransactionID restores to the given savepoint
+// RestoreToTransactionID restores the latest backup and recovers up to the given transaction
 func (ctl Controller) RestoreToTransactionID(txID int64) *pitr.Error {
+	return ctl.restoreTo(fmt.Sprintf("recovery_target_xid = '%d'", txID))
+}
+
+// RestoreToLSN restores the latest backup and recovers up to the given position in the WAL
+func (ctl Controller) RestoreToLSN(lsn pitr.LSN) *pitr.Error {
+	version, parseErr := pitr.ParseVersion(ctl.cluster.Version)
+
+	if parseErr != nil {
+		return &pitr.Error{
+			Message: parseErr.Error(),
+		}
+	}
+
+	if version.Compare(pitr.FirstVersionWithLSNTarget) < 0 {
+		return &pitr.Error{
+			Message: fmt.Sprintf("PostgreSQL %s cannot recover to an LSN", version.MajorString()),
+		}
+	}
+
+	return ctl.restoreTo(fmt.Sprintf("recovery_target_lsn = '%s'", lsn))
+}
+
+// restoreTo restores the latest backup and recovers up to the given recovery target setting, then promotes
+func (ctl Controller) restoreTo(target string) *pitr.Error {
 	err := ctl.cluster.Stop()
 
 	if err != nil {
@@ -118,24 +144,16 @@ func (ctl Controller) RestoreToTransactionID(txID int64) *pitr.Error {
 		}
 	}
 
-	ctl.createRecoveryConf(
-		`restore_command = 'bash --login -c \"wal-g wal-fetch %f %p\"'`,
-		fmt.Sprintf("recovery_target_xid = %d", txID),
-		"recovery_target_action=promote",
+	err = ctl.cluster.ConfigureRecovery(
+		restoreCommand,
+		target,
+		"recovery_target_action = 'promote'",
 	)
 
 	if err != nil {
 		return err
 	}
 
-	if runErr != nil {
-		return &pitr.Error{
-			Message: runErr.Error(),
-			Stdout:  stdout,
-			Stderr:  stderr,
-		}
-	}
-
 	err = ctl.cluster.Start()
 
 	if err != nil {
@@ -169,19 +187,3 @@ func (ctl Controller) List() (*Info, *pitr.Error) {
 
 	return infos, nil
 }
-
-func (ctl Controller) createRecoveryConf(commands ...string) *pitr.Error {
-	for _, command := range commands {
-		stdout, stderr, runErr := ctl.runner.Run(`echo "%s" | sudo --login --user postgres tee --append %s/recovery.conf`, command, ctl.cluster.DataDirectory())
-
-		if runErr != nil {
-			return &pitr.Error{
-				Message: runErr.Error(),
-				Stdout:  stdout,
-				Stderr:  stderr,
-			}
-		}
-	}
-
-	return nil
-}
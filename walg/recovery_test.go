@@ -0,0 +1,173 @@
+package walg_test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	pitr "github.com/suhlig/postgres-pitr"
+	"github.com/suhlig/postgres-pitr/cluster"
+	h "github.com/suhlig/postgres-pitr/helpers"
+	"github.com/suhlig/postgres-pitr/walg"
+)
+
+var _ = Describe("recovery configuration", func() {
+	for _, v := range []string{"9.6", "10", "11"} {
+		version := v
+
+		Context(fmt.Sprintf("PostgreSQL %s", version), func() {
+			var runner *h.FakeRunner
+			var dataDirectory string
+
+			BeforeEach(func() {
+				runner = &h.FakeRunner{}
+				runner.On("PG_VERSION", version+"\n", "", nil)
+
+				cluster := cluster.NewController(runner, version, "main")
+				dataDirectory = cluster.DataDirectory()
+
+				err := walg.NewController(runner, cluster).RestoreToTransactionID(4711)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("writes the recovery target to recovery.conf", func() {
+				Expect(runner.CommandsContaining(dataDirectory + "/recovery.conf")).To(ConsistOf(
+					And(
+						ContainSubstring("restore_command = 'bash --login -c"),
+						ContainSubstring("recovery_target_xid = '4711'"),
+						ContainSubstring("recovery_target_action = 'promote'"),
+					),
+				))
+			})
+
+			It("does not touch postgresql.auto.conf", func() {
+				Expect(runner.CommandsContaining("postgresql.auto.conf")).To(BeEmpty())
+			})
+
+			It("does not create a signal file", func() {
+				Expect(runner.CommandsContaining(".signal")).To(BeEmpty())
+			})
+		})
+	}
+
+	for _, v := range []string{"12", "13", "14", "15", "16"} {
+		version := v
+
+		Context(fmt.Sprintf("PostgreSQL %s", version), func() {
+			var runner *h.FakeRunner
+			var dataDirectory string
+
+			BeforeEach(func() {
+				runner = &h.FakeRunner{}
+				runner.On("PG_VERSION", version+"\n", "", nil)
+
+				cluster := cluster.NewController(runner, version, "main")
+				dataDirectory = cluster.DataDirectory()
+
+				err := walg.NewController(runner, cluster).RestoreToTransactionID(4711)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("does not write recovery.conf", func() {
+				Expect(runner.CommandsContaining("recovery.conf")).To(BeEmpty())
+			})
+
+			It("replaces earlier recovery settings in postgresql.auto.conf", func() {
+				Expect(runner.CommandsContaining("sed --in-place")).To(ConsistOf(
+					And(
+						ContainSubstring("restore_command|recovery_target_xid|recovery_target_action"),
+						ContainSubstring(dataDirectory+"/postgresql.auto.conf"),
+					),
+				))
+			})
+
+			It("appends the recovery target to postgresql.auto.conf", func() {
+				Expect(runner.CommandsContaining("tee --append " + dataDirectory + "/postgresql.auto.conf")).To(ConsistOf(
+					And(
+						ContainSubstring("restore_command = 'bash --login -c"),
+						ContainSubstring("recovery_target_xid = '4711'"),
+						ContainSubstring("recovery_target_action = 'promote'"),
+					),
+				))
+			})
+
+			It("creates recovery.signal", func() {
+				Expect(runner.CommandsContaining(dataDirectory + "/recovery.signal")).To(HaveLen(1))
+			})
+
+			It("removes a leftover standby.signal", func() {
+				Expect(runner.CommandsContaining("standby.signal")).To(ConsistOf("sudo --user postgres rm --force " + dataDirectory + "/standby.signal"))
+			})
+		})
+	}
+})
+
+var _ = Describe("recovery to an LSN", func() {
+	var runner *h.FakeRunner
+	var version string
+	var err *pitr.Error
+
+	BeforeEach(func() {
+		runner = &h.FakeRunner{}
+	})
+
+	JustBeforeEach(func() {
+		runner.On("PG_VERSION", version+"\n", "", nil)
+		err = walg.NewController(runner, cluster.NewController(runner, version, "main")).RestoreToLSN(pitr.LSN(0x16B374D848))
+	})
+
+	Context("PostgreSQL 13", func() {
+		BeforeEach(func() {
+			version = "13"
+		})
+
+		It("writes the recovery target", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.CommandsContaining("tee --append")).To(ConsistOf(
+				ContainSubstring("recovery_target_lsn = '16/B374D848'"),
+			))
+		})
+	})
+
+	Context("after an earlier recovery to a transaction ID", func() {
+		BeforeEach(func() {
+			version = "13"
+			runner.On("PG_VERSION", version+"\n", "", nil)
+			Expect(walg.NewController(runner, cluster.NewController(runner, version, "main")).RestoreToTransactionID(4711)).To(BeNil())
+		})
+
+		It("removes all settings of the earlier recovery", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			appended := runner.CommandsContaining("tee --append")
+			Expect(appended).To(HaveLen(2))
+			earlier := regexp.MustCompile(`(?s)echo "(.*)" \|`).FindStringSubmatch(appended[0])[1]
+
+			removals := runner.CommandsContaining("sed --in-place")
+			Expect(removals).To(HaveLen(2))
+			expression := regexp.MustCompile(`'/(.*)/d'`).FindStringSubmatch(removals[1])[1]
+			pattern := regexp.MustCompile(expression)
+
+			for _, line := range strings.Split(earlier, "\n") {
+				Expect(pattern.MatchString(line)).To(BeTrue(), line)
+			}
+
+			Expect(pattern.MatchString("primary_conninfo = 'host=primary'")).To(BeTrue())
+			Expect(pattern.MatchString("primary_slot_name = 'standby'")).To(BeTrue())
+			Expect(pattern.MatchString("shared_buffers = '1GB'")).To(BeFalse())
+		})
+	})
+
+	Context("PostgreSQL 9.6", func() {
+		BeforeEach(func() {
+			version = "9.6"
+		})
+
+		It("does not stop the cluster", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(runner.Commands).To(BeEmpty())
+		})
+	})
+})